@@ -1,23 +1,27 @@
 package config
 
 import (
+	"crypto/rand"
 	"fmt"
 	"github.com/joho/godotenv"
-	"log"
+	"io"
+	"log/slog"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
 func LoadConfig(path string) IConfig {
 	envMap, err := godotenv.Read(path)
 	if err != nil {
-		log.Fatalf("load dotenv failed: %v", err)
+		fatal("load dotenv failed", "path", path, "err", err)
 	}
 
 	parseInt := func(key string) int {
 		value, err := strconv.Atoi(envMap[key])
 		if err != nil {
-			log.Fatalf("load %s failed: %v", key, err)
+			fatal("load setting failed", "key", key, "err", err)
 		}
 		return value
 	}
@@ -27,14 +31,57 @@ func LoadConfig(path string) IConfig {
 		return time.Duration(value) * time.Second
 	}
 
-	return &config{
+	parseIntOrDefault := func(key string, fallback int) int {
+		if envMap[key] == "" {
+			return fallback
+		}
+		return parseInt(key)
+	}
+
+	parseDurationOrDefault := func(key string, fallback time.Duration) time.Duration {
+		if envMap[key] == "" {
+			return fallback
+		}
+		return parseDuration(key)
+	}
+
+	stringOrDefault := func(key, fallback string) string {
+		if envMap[key] == "" {
+			return fallback
+		}
+		return envMap[key]
+	}
+
+	parseBoolOrDefault := func(key string, fallback bool) bool {
+		if envMap[key] == "" {
+			return fallback
+		}
+		value, err := strconv.ParseBool(envMap[key])
+		if err != nil {
+			fatal("load setting failed", "key", key, "err", err)
+		}
+		return value
+	}
+
+	// Logging is set up first, so that everything after, loading included, logs in one format.
+	logCfg := &logConfig{format: strings.ToLower(stringOrDefault("LOG_FORMAT", "json"))}
+	if logCfg.format != "json" && logCfg.format != "text" {
+		fatal("unknown LOG_FORMAT, want json or text", "value", logCfg.format)
+	}
+	if err := logCfg.level.UnmarshalText([]byte(stringOrDefault("LOG_LEVEL", "info"))); err != nil {
+		fatal("load setting failed", "key", "LOG_LEVEL", "err", err)
+	}
+	slog.SetDefault(logCfg.Logger(os.Stdout))
+
+	cfg := &config{
 		app: &app{
-			host:         envMap["APP_HOST"],
-			port:         parseInt("APP_PORT"),
-			name:         envMap["APP_NAME"],
-			version:      envMap["APP_VERSION"],
-			readTimeout:  parseDuration("APP_READ_TIMEOUT"),
-			writeTimeout: parseDuration("APP_WRITE_TIMEOUT"),
+			host:           envMap["APP_HOST"],
+			port:           parseInt("APP_PORT"),
+			name:           envMap["APP_NAME"],
+			version:        envMap["APP_VERSION"],
+			readTimeout:    parseDuration("APP_READ_TIMEOUT"),
+			writeTimeout:   parseDuration("APP_WRITE_TIMEOUT"),
+			trustedProxies: splitList(envMap["APP_TRUSTED_PROXIES"]),
 		},
 		db: &db{
 			host:           envMap["DB_HOST"],
@@ -46,12 +93,156 @@ func LoadConfig(path string) IConfig {
 			maxConnections: parseInt("DB_MAX_CONNECTIONS"),
 			mongoURI:       envMap["MONGO_URI"],
 		},
+		beer: &beer{
+			purgeRetention:   parseDurationOrDefault("BEER_PURGE_RETENTION", 30*24*time.Hour),
+			purgeInterval:    parseDurationOrDefault("BEER_PURGE_INTERVAL", time.Hour),
+			imageMaxSize:     int64(parseIntOrDefault("BEER_IMAGE_MAX_SIZE", 5<<20)),
+			imageMaxPixels:   int64(parseIntOrDefault("BEER_IMAGE_MAX_PIXELS", 25_000_000)),
+			cursorSecret:     cursorSecret(envMap["BEER_CURSOR_SECRET"]),
+			cursorTTL:        parseDurationOrDefault("BEER_CURSOR_TTL", 24*time.Hour),
+			minPageLimit:     parseIntOrDefault("BEER_PAGE_MIN_LIMIT", 1),
+			defaultPageLimit: parseIntOrDefault("BEER_PAGE_DEFAULT_LIMIT", 10),
+			maxPageLimit:     parseIntOrDefault("BEER_PAGE_MAX_LIMIT", 100),
+			importMaxSize:    int64(parseIntOrDefault("BEER_IMPORT_MAX_SIZE", 50<<20)),
+			importMaxImages:  int64(parseIntOrDefault("BEER_IMPORT_MAX_IMAGES_SIZE", 200<<20)),
+			importMaxRows:    parseIntOrDefault("BEER_IMPORT_MAX_ROWS", 10000),
+			importChunkSize:  parseIntOrDefault("BEER_IMPORT_CHUNK_SIZE", 100),
+		},
+		storage: &storage{
+			driver:      stringOrDefault("STORAGE_DRIVER", "local"),
+			localRoot:   stringOrDefault("STORAGE_LOCAL_ROOT", "uploads"),
+			publicURL:   envMap["STORAGE_PUBLIC_URL"],
+			s3Endpoint:  envMap["STORAGE_S3_ENDPOINT"],
+			s3Region:    stringOrDefault("STORAGE_S3_REGION", "us-east-1"),
+			s3Bucket:    envMap["STORAGE_S3_BUCKET"],
+			s3AccessKey: envMap["STORAGE_S3_ACCESS_KEY"],
+			s3SecretKey: envMap["STORAGE_S3_SECRET_KEY"],
+			s3PathStyle: parseBoolOrDefault("STORAGE_S3_PATH_STYLE", true),
+		},
+		jwt: &jwt{
+			hmacSecret:   envMap["JWT_HMAC_SECRET"],
+			rsaPublicKey: envMap["JWT_RSA_PUBLIC_KEY_FILE"],
+			jwksFile:     envMap["JWT_JWKS_FILE"],
+			issuer:       envMap["JWT_ISSUER"],
+			audience:     envMap["JWT_AUDIENCE"],
+			leeway:       parseDurationOrDefault("JWT_LEEWAY", 30*time.Second),
+		},
+		metrics: &metrics{
+			addr: envMap["METRICS_ADDR"],
+			path: stringOrDefault("METRICS_PATH", "/metrics"),
+		},
+		log: logCfg,
+		rateLimit: &rateLimit{
+			enabled: parseBoolOrDefault("RATE_LIMIT_ENABLED", true),
+			groups:  make(map[string]RateLimit),
+		},
+	}
+
+	for group, fallback := range map[string]string{
+		RateLimitRead:  "300/1m",
+		RateLimitWrite: "60/1m",
+		RateLimitAdmin: "30/1m",
+		RateLimitAuth:  "600/1m",
+	} {
+		key := "RATE_LIMIT_" + strings.ToUpper(group)
+		limit, ok := parseRateLimit(key, stringOrDefault(key, fallback))
+		if !ok {
+			continue
+		}
+		limit.Burst = parseIntOrDefault(key+"_BURST", limit.Requests)
+		if limit.Burst < 1 {
+			fatal("invalid rate limit burst, must be at least 1", "key", key+"_BURST", "value", limit.Burst)
+		}
+		cfg.rateLimit.groups[group] = limit
+	}
+
+	if !strings.HasPrefix(cfg.metrics.path, "/") {
+		fatal("invalid METRICS_PATH, must start with /", "value", cfg.metrics.path)
+	}
+
+	b := cfg.beer
+	if b.minPageLimit < 1 || b.defaultPageLimit < b.minPageLimit || b.maxPageLimit < b.defaultPageLimit {
+		fatal("invalid page limits, need 1 <= BEER_PAGE_MIN_LIMIT <= BEER_PAGE_DEFAULT_LIMIT <= BEER_PAGE_MAX_LIMIT",
+			"min", b.minPageLimit, "default", b.defaultPageLimit, "max", b.maxPageLimit)
+	}
+	if b.importChunkSize < 1 {
+		fatal("invalid BEER_IMPORT_CHUNK_SIZE, must be at least 1", "value", b.importChunkSize)
+	}
+
+	return cfg
+}
+
+// parseRateLimit reads a "<requests>/<period>" quota such as "300/1m"; the period is a Go
+// duration or a number of seconds. "off" disables the limit.
+func parseRateLimit(key, value string) (RateLimit, bool) {
+	if strings.EqualFold(value, "off") {
+		return RateLimit{}, false
+	}
+	requestsStr, periodStr, found := strings.Cut(value, "/")
+	requests, err := strconv.Atoi(strings.TrimSpace(requestsStr))
+	if !found || err != nil || requests < 1 {
+		fatal("invalid rate limit, want <requests>/<period>, e.g. 300/1m, or off", "key", key, "value", value)
+	}
+	periodStr = strings.TrimSpace(periodStr)
+	period, err := time.ParseDuration(periodStr)
+	if err != nil {
+		seconds, convErr := strconv.Atoi(periodStr)
+		if convErr != nil {
+			fatal("invalid rate limit period", "key", key, "value", value, "err", err)
+		}
+		period = time.Duration(seconds) * time.Second
+	}
+	if period <= 0 {
+		fatal("invalid rate limit, period must be positive", "key", key, "value", value)
+	}
+	// Buckets refill one token every period/requests, which must not round down to nothing.
+	if period < time.Duration(requests) {
+		fatal("invalid rate limit, more than one request per nanosecond", "key", key, "value", value)
+	}
+	return RateLimit{Requests: requests, Period: period}, true
+}
+
+// fatal logs msg at error level and exits. It stands in for the logger package, which imports
+// config.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// splitList splits a comma-separated setting, dropping empty entries.
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// cursorSecret returns the key pagination cursors are signed with. Without one a random key is
+// used, which means cursors only work against the instance that issued them.
+func cursorSecret(value string) []byte {
+	if value != "" {
+		return []byte(value)
+	}
+	slog.Warn("BEER_CURSOR_SECRET is not set, using a random cursor signing key")
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		fatal("generate cursor secret failed", "err", err)
 	}
+	return secret
 }
 
 type IConfig interface {
 	App() IAppConfig
 	Db() IDbConfig
+	Beer() IBeerConfig
+	Storage() IStorageConfig
+	Jwt() IJwtConfig
+	RateLimit() IRateLimitConfig
+	Log() ILogConfig
+	Metrics() IMetricsConfig
 }
 
 type IAppConfig interface {
@@ -60,6 +251,9 @@ type IAppConfig interface {
 	Version() string
 	ReadTimeout() time.Duration
 	WriteTimeout() time.Duration
+	// TrustedProxies are the proxy IPs or CIDRs whose X-Forwarded-For is believed when working
+	// out the client IP. Empty means the connection's peer address is the client.
+	TrustedProxies() []string
 }
 
 func (a *app) Url() string                 { return fmt.Sprintf("%s:%d", a.host, a.port) }
@@ -67,6 +261,7 @@ func (a *app) Name() string                { return a.name }
 func (a *app) Version() string             { return a.version }
 func (a *app) ReadTimeout() time.Duration  { return a.readTimeout }
 func (a *app) WriteTimeout() time.Duration { return a.writeTimeout }
+func (a *app) TrustedProxies() []string    { return a.trustedProxies }
 
 type IDbConfig interface {
 	Url() string
@@ -86,18 +281,127 @@ func (d *db) MaxOpenConns() int {
 	return d.maxConnections
 }
 
+type IBeerConfig interface {
+	PurgeRetention() time.Duration
+	PurgeInterval() time.Duration
+	ImageMaxSize() int64
+	// ImageMaxPixels limits width x height of uploaded images, which decoding needs 4 bytes each for.
+	ImageMaxPixels() int64
+	CursorSecret() []byte
+	// CursorTTL is how long a pagination cursor stays valid after it is issued; zero never expires.
+	CursorTTL() time.Duration
+	MinPageLimit() int
+	DefaultPageLimit() int
+	MaxPageLimit() int
+	ImportMaxSize() int64
+	// ImportMaxImagesSize caps the uncompressed bytes of all images read from one import archive,
+	// which are held in memory until the import is done.
+	ImportMaxImagesSize() int64
+	ImportMaxRows() int
+	ImportChunkSize() int
+}
+
+func (b *beer) PurgeRetention() time.Duration { return b.purgeRetention }
+func (b *beer) PurgeInterval() time.Duration  { return b.purgeInterval }
+func (b *beer) ImageMaxSize() int64           { return b.imageMaxSize }
+func (b *beer) ImageMaxPixels() int64         { return b.imageMaxPixels }
+func (b *beer) CursorSecret() []byte          { return b.cursorSecret }
+func (b *beer) CursorTTL() time.Duration      { return b.cursorTTL }
+func (b *beer) MinPageLimit() int             { return b.minPageLimit }
+func (b *beer) DefaultPageLimit() int         { return b.defaultPageLimit }
+func (b *beer) MaxPageLimit() int             { return b.maxPageLimit }
+func (b *beer) ImportMaxSize() int64          { return b.importMaxSize }
+func (b *beer) ImportMaxImagesSize() int64    { return b.importMaxImages }
+func (b *beer) ImportMaxRows() int            { return b.importMaxRows }
+func (b *beer) ImportChunkSize() int          { return b.importChunkSize }
+
+type IStorageConfig interface {
+	Driver() string
+	LocalRoot() string
+	// PublicURL is the prefix clients download S3 objects from, e.g. a CDN in front of the
+	// bucket. Local and memory files are always served through the API.
+	PublicURL() string
+	S3Endpoint() string
+	S3Region() string
+	S3Bucket() string
+	S3AccessKey() string
+	S3SecretKey() string
+	S3PathStyle() bool
+}
+
+func (s *storage) Driver() string      { return s.driver }
+func (s *storage) LocalRoot() string   { return s.localRoot }
+func (s *storage) PublicURL() string   { return s.publicURL }
+func (s *storage) S3Endpoint() string  { return s.s3Endpoint }
+func (s *storage) S3Region() string    { return s.s3Region }
+func (s *storage) S3Bucket() string    { return s.s3Bucket }
+func (s *storage) S3AccessKey() string { return s.s3AccessKey }
+func (s *storage) S3SecretKey() string { return s.s3SecretKey }
+func (s *storage) S3PathStyle() bool   { return s.s3PathStyle }
+
+type IJwtConfig interface {
+	HMACSecret() []byte
+	RSAPublicKeyFile() string
+	JWKSFile() string
+	Issuer() string
+	Audience() string
+	Leeway() time.Duration
+}
+
+func (j *jwt) HMACSecret() []byte       { return []byte(j.hmacSecret) }
+func (j *jwt) RSAPublicKeyFile() string { return j.rsaPublicKey }
+func (j *jwt) JWKSFile() string         { return j.jwksFile }
+func (j *jwt) Issuer() string           { return j.issuer }
+func (j *jwt) Audience() string         { return j.audience }
+func (j *jwt) Leeway() time.Duration    { return j.leeway }
+
+// Rate limit groups; each route group is limited by one of them.
+const (
+	RateLimitRead  = "read"
+	RateLimitWrite = "write"
+	RateLimitAdmin = "admin"
+	// RateLimitAuth limits, per client IP, requests presenting credentials before they are checked.
+	RateLimitAuth = "auth"
+)
+
+// RateLimit is a token bucket quota: Requests per Period on average, in bursts of up to Burst.
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+	Burst    int
+}
+
+type IRateLimitConfig interface {
+	Enabled() bool
+	// Group returns the quota of a rate limit group, or false when the group is not limited.
+	Group(name string) (RateLimit, bool)
+}
+
+func (r *rateLimit) Enabled() bool { return r.enabled }
+func (r *rateLimit) Group(name string) (RateLimit, bool) {
+	limit, ok := r.groups[name]
+	return limit, ok && r.enabled
+}
+
 type config struct {
-	app *app
-	db  *db
+	app       *app
+	db        *db
+	beer      *beer
+	storage   *storage
+	jwt       *jwt
+	rateLimit *rateLimit
+	log       *logConfig
+	metrics   *metrics
 }
 
 type app struct {
-	host         string
-	port         int
-	name         string
-	version      string
-	readTimeout  time.Duration
-	writeTimeout time.Duration
+	host           string
+	port           int
+	name           string
+	version        string
+	readTimeout    time.Duration
+	writeTimeout   time.Duration
+	trustedProxies []string
 }
 
 type db struct {
@@ -118,3 +422,116 @@ func (c *config) App() IAppConfig {
 func (c *config) Db() IDbConfig {
 	return c.db
 }
+
+type beer struct {
+	purgeRetention   time.Duration
+	purgeInterval    time.Duration
+	imageMaxSize     int64
+	imageMaxPixels   int64
+	cursorSecret     []byte
+	cursorTTL        time.Duration
+	minPageLimit     int
+	defaultPageLimit int
+	maxPageLimit     int
+	importMaxSize    int64
+	importMaxImages  int64
+	importMaxRows    int
+	importChunkSize  int
+}
+
+func (c *config) Beer() IBeerConfig {
+	return c.beer
+}
+
+type storage struct {
+	driver      string
+	localRoot   string
+	publicURL   string
+	s3Endpoint  string
+	s3Region    string
+	s3Bucket    string
+	s3AccessKey string
+	s3SecretKey string
+	s3PathStyle bool
+}
+
+func (c *config) Storage() IStorageConfig {
+	return c.storage
+}
+
+type jwt struct {
+	hmacSecret   string
+	rsaPublicKey string
+	jwksFile     string
+	issuer       string
+	audience     string
+	leeway       time.Duration
+}
+
+func (c *config) Jwt() IJwtConfig {
+	return c.jwt
+}
+
+type rateLimit struct {
+	enabled bool
+	groups  map[string]RateLimit
+}
+
+func (c *config) RateLimit() IRateLimitConfig {
+	return c.rateLimit
+}
+
+type ILogConfig interface {
+	Level() slog.Level
+	// Format is "json" or "text".
+	Format() string
+	// Logger builds a logger writing to w in this level and format. LoadConfig installs one
+	// writing to stdout as slog's default.
+	Logger(w io.Writer) *slog.Logger
+}
+
+type logConfig struct {
+	level  slog.Level
+	format string
+}
+
+func (c *config) Log() ILogConfig {
+	return c.log
+}
+
+func (l *logConfig) Level() slog.Level { return l.level }
+func (l *logConfig) Format() string    { return l.format }
+
+// Logger builds the application logger. Every layer logs through it, using these field names
+// so entries can be searched the same way everywhere:
+//
+//	request_id, client_ip, method, route, path, status, duration, actor_kind, actor_id, err
+func (l *logConfig) Logger(w io.Writer) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: l.level}
+	if l.format == "text" {
+		return slog.New(slog.NewTextHandler(w, opts))
+	}
+	return slog.New(slog.NewJSONHandler(w, opts))
+}
+
+type IMetricsConfig interface {
+	// Enabled reports whether Addr is set. Metrics are never served on the public API
+	// listener, as they expose routes and internals to anyone.
+	Enabled() bool
+	// Addr is the host:port of the separate listener for the metrics endpoint.
+	Addr() string
+	Path() string
+}
+
+type metrics struct {
+	addr string
+	path string
+}
+
+func (c *config) Metrics() IMetricsConfig {
+	return c.metrics
+}
+
+func (m *metrics) Enabled() bool { return m.addr != "" }
+func (m *metrics) Addr() string  { return m.addr }
+func (m *metrics) Path() string  { return m.path }
@@ -5,18 +5,32 @@ import (
 	"github.com/peedans/beerleo/config"
 	"github.com/peedans/beerleo/modules/servers"
 	"github.com/peedans/beerleo/pkg/databases"
+	"github.com/peedans/beerleo/pkg/databases/migrations"
+	"github.com/peedans/beerleo/pkg/logger"
 	"os"
 )
 
 func envPath() string {
-	if len(os.Args) == 1 {
+	if len(os.Args) == 1 || os.Args[1] == "migrate" {
 		return ".env.dev"
 	} else {
 		return os.Args[1]
 	}
 }
 
+// migrateArgs returns the arguments following "migrate", e.g. `beerleo .env.prod migrate to 2`.
+func migrateArgs() ([]string, bool) {
+	for i, arg := range os.Args[1:] {
+		if arg == "migrate" {
+			return os.Args[i+2:], true
+		}
+	}
+	return nil, false
+}
+
 func main() {
+	// LoadConfig installs the configured logger as slog's default, which the standard log
+	// package writes through too.
 	cfg := config.LoadConfig(envPath())
 
 	db := databases.DbConnect(cfg.Db())
@@ -27,6 +41,17 @@ func main() {
 		}
 	}(db)
 
+	if args, ok := migrateArgs(); ok {
+		migrator, err := migrations.Migrator(db)
+		if err != nil {
+			logger.Fatal("load migrations failed", "err", err)
+		}
+		if err := migrations.Run(migrator, args); err != nil {
+			logger.Fatal("migrate failed", "err", err)
+		}
+		return
+	}
+
 	servers.NewServer(cfg, db).Start()
 
 }
@@ -0,0 +1,58 @@
+package apikeys
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type ApiKey struct {
+	ID     int    `db:"id" json:"id"`
+	Name   string `db:"name" json:"name"`
+	Prefix string `db:"prefix" json:"prefix"`
+	// KeyHash is the bcrypt hash of the full key; the key itself is never stored.
+	KeyHash    string     `db:"key_hash" json:"-"`
+	Scopes     Scopes     `db:"scopes" json:"scopes"`
+	CreatedBy  string     `db:"created_by" json:"created_by"`
+	ExpiresAt  *time.Time `db:"expires_at" json:"expires_at"`
+	LastUsedAt *time.Time `db:"last_used_at" json:"last_used_at"`
+	RevokedAt  *time.Time `db:"revoked_at" json:"revoked_at"`
+	CreatedAt  *time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt  *time.Time `db:"updated_at" json:"updated_at"`
+	// Key is only filled in when the key is created or rotated; it cannot be retrieved later.
+	Key string `db:"-" json:"key,omitempty"`
+}
+
+// Active reports whether the key is neither revoked nor expired at now.
+func (k *ApiKey) Active(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// Scopes is stored as a space-separated list.
+type Scopes []string
+
+func (s Scopes) Value() (driver.Value, error) {
+	return strings.Join(s, " "), nil
+}
+
+func (s *Scopes) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case string:
+		*s = strings.Fields(v)
+	case []byte:
+		*s = strings.Fields(string(v))
+	case nil:
+		*s = nil
+	default:
+		return fmt.Errorf("cannot scan %T into Scopes", src)
+	}
+	return nil
+}
+
+// ApiKeyRequest creates a key. A nil ExpiresAt makes a key that never expires.
+type ApiKeyRequest struct {
+	Name      string     `json:"name" binding:"required,max=255"`
+	Scopes    []string   `json:"scopes" binding:"required,min=1,dive,required"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
@@ -0,0 +1,120 @@
+package apikeysHandlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/peedans/beerleo/modules/apikeys"
+	"github.com/peedans/beerleo/modules/apikeys/apikeysUsecases"
+	"github.com/peedans/beerleo/pkg/apperrors"
+	"github.com/peedans/beerleo/pkg/auth"
+	"net/http"
+	"strconv"
+)
+
+type IApiKeysHandler interface {
+	ListApiKeys(c *gin.Context)
+	GetApiKey(c *gin.Context)
+	CreateApiKey(c *gin.Context)
+	RotateApiKey(c *gin.Context)
+	RevokeApiKey(c *gin.Context)
+}
+
+type apikeysHandler struct {
+	apikeysUsecase apikeysUsecases.IApiKeysUsecase
+}
+
+func ApiKeysHandler(apikeysUsecase apikeysUsecases.IApiKeysUsecase) IApiKeysHandler {
+	return &apikeysHandler{
+		apikeysUsecase: apikeysUsecase,
+	}
+}
+
+func (h *apikeysHandler) ListApiKeys(c *gin.Context) {
+	list, err := h.apikeysUsecase.ListApiKeys()
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": list})
+}
+
+func (h *apikeysHandler) GetApiKey(c *gin.Context) {
+	id, err := getApiKeyID(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	key, err := h.apikeysUsecase.GetApiKey(id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, key)
+}
+
+// CreateApiKey responds with the new key in the "key" field. It is not stored and cannot be
+// shown again.
+func (h *apikeysHandler) CreateApiKey(c *gin.Context) {
+	var req apikeys.ApiKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		_ = c.Error(apperrors.Binding(err))
+		return
+	}
+
+	createdBy := ""
+	if principal, ok := auth.GetPrincipal(c); ok {
+		createdBy = principal.ID
+	}
+
+	key, err := h.apikeysUsecase.CreateApiKey(&req, createdBy)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusCreated, key)
+}
+
+// RotateApiKey issues a replacement key, returned once like on creation.
+func (h *apikeysHandler) RotateApiKey(c *gin.Context) {
+	id, err := getApiKeyID(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	key, err := h.apikeysUsecase.RotateApiKey(id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, key)
+}
+
+func (h *apikeysHandler) RevokeApiKey(c *gin.Context) {
+	id, err := getApiKeyID(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	if err := h.apikeysUsecase.RevokeApiKey(id); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func getApiKeyID(c *gin.Context) (int, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		return 0, apperrors.Validation("Invalid API key ID")
+	}
+	return id, nil
+}
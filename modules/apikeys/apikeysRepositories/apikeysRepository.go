@@ -0,0 +1,124 @@
+package apikeysRepositories
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/jmoiron/sqlx"
+	"github.com/peedans/beerleo/modules/apikeys"
+	"github.com/peedans/beerleo/pkg/apperrors"
+	"github.com/peedans/beerleo/pkg/metrics"
+)
+
+// repositoryName labels the query duration metrics of this repository.
+const repositoryName = "api_keys"
+
+type IApiKeysRepository interface {
+	List() ([]*apikeys.ApiKey, error)
+	GetByID(id int) (*apikeys.ApiKey, error)
+	GetByPrefix(prefix string) (*apikeys.ApiKey, error)
+	Create(key *apikeys.ApiKey) (int, error)
+	Rotate(id int, prefix, keyHash string) error
+	Revoke(id int) error
+	Touch(id int) error
+}
+
+type apikeysRepository struct {
+	db *sqlx.DB
+}
+
+func ApiKeysRepository(db *sqlx.DB) IApiKeysRepository {
+	return &apikeysRepository{
+		db: db,
+	}
+}
+
+func (r *apikeysRepository) List() ([]*apikeys.ApiKey, error) {
+	defer metrics.ObserveQuery(repositoryName, "List")()
+	list := make([]*apikeys.ApiKey, 0)
+	if err := r.db.Select(&list, "SELECT * FROM api_keys ORDER BY id"); err != nil {
+		return nil, apperrors.Internal(err, "Failed to list API keys")
+	}
+	return list, nil
+}
+
+func (r *apikeysRepository) GetByID(id int) (*apikeys.ApiKey, error) {
+	defer metrics.ObserveQuery(repositoryName, "GetByID")()
+	var key apikeys.ApiKey
+	err := r.db.Get(&key, "SELECT * FROM api_keys WHERE id=?", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apperrors.NotFound("API key with ID %d not found", id)
+	}
+	if err != nil {
+		return nil, apperrors.Internal(err, "Failed to retrieve API key %d", id)
+	}
+	return &key, nil
+}
+
+func (r *apikeysRepository) GetByPrefix(prefix string) (*apikeys.ApiKey, error) {
+	defer metrics.ObserveQuery(repositoryName, "GetByPrefix")()
+	var key apikeys.ApiKey
+	err := r.db.Get(&key, "SELECT * FROM api_keys WHERE prefix=?", prefix)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apperrors.NotFound("API key not found")
+	}
+	if err != nil {
+		return nil, apperrors.Internal(err, "Failed to retrieve API key")
+	}
+	return &key, nil
+}
+
+func (r *apikeysRepository) Create(key *apikeys.ApiKey) (int, error) {
+	defer metrics.ObserveQuery(repositoryName, "Create")()
+	result, err := r.db.NamedExec(`INSERT INTO api_keys(name, prefix, key_hash, scopes, created_by, expires_at)
+		VALUES (:name, :prefix, :key_hash, :scopes, :created_by, :expires_at)`, key)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(id), nil
+}
+
+// Rotate replaces the key of an unrevoked API key, which invalidates the old one at once.
+func (r *apikeysRepository) Rotate(id int, prefix, keyHash string) error {
+	defer metrics.ObserveQuery(repositoryName, "Rotate")()
+	result, err := r.db.Exec("UPDATE api_keys SET prefix=?, key_hash=? WHERE id=? AND revoked_at IS NULL", prefix, keyHash, id)
+	if err != nil {
+		return err
+	}
+	return r.checkAffected(result, id)
+}
+
+func (r *apikeysRepository) Revoke(id int) error {
+	defer metrics.ObserveQuery(repositoryName, "Revoke")()
+	result, err := r.db.Exec("UPDATE api_keys SET revoked_at=NOW() WHERE id=? AND revoked_at IS NULL", id)
+	if err != nil {
+		return err
+	}
+	return r.checkAffected(result, id)
+}
+
+// Touch records when the key was last used. It leaves updated_at alone, which tracks changes
+// to the key itself.
+func (r *apikeysRepository) Touch(id int) error {
+	defer metrics.ObserveQuery(repositoryName, "Touch")()
+	_, err := r.db.Exec("UPDATE api_keys SET last_used_at=NOW(), updated_at=updated_at WHERE id=?", id)
+	return err
+}
+
+// checkAffected tells a missing key apart from a revoked one when an update matched no rows.
+func (r *apikeysRepository) checkAffected(result sql.Result, id int) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected > 0 {
+		return nil
+	}
+	if _, err := r.GetByID(id); err != nil {
+		return err
+	}
+	return apperrors.Conflict("API key with ID %d is revoked", id)
+}
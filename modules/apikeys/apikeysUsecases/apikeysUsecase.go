@@ -0,0 +1,253 @@
+package apikeysUsecases
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/peedans/beerleo/modules/apikeys"
+	"github.com/peedans/beerleo/modules/apikeys/apikeysRepositories"
+	"github.com/peedans/beerleo/pkg/apperrors"
+	"github.com/peedans/beerleo/pkg/auth"
+	"github.com/peedans/beerleo/pkg/logger"
+	"golang.org/x/crypto/bcrypt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// keyPrefix starts every API key so leaked keys are easy to recognise, e.g. by secret scanners.
+// A key reads "bk_<prefix>_<secret>"; the prefix is stored in clear to look the key up.
+const keyPrefix = "bk_"
+
+// touchEvery limits how often a key's last_used_at is written.
+const touchEvery = time.Minute
+
+type IApiKeysUsecase interface {
+	auth.IKeyAuthenticator
+	ListApiKeys() ([]*apikeys.ApiKey, error)
+	GetApiKey(id int) (*apikeys.ApiKey, error)
+	CreateApiKey(req *apikeys.ApiKeyRequest, createdBy string) (*apikeys.ApiKey, error)
+	RotateApiKey(id int) (*apikeys.ApiKey, error)
+	RevokeApiKey(id int) error
+}
+
+type apikeysUsecase struct {
+	apikeysRepository apikeysRepositories.IApiKeysRepository
+
+	// verified remembers keys that passed bcrypt, so it runs once per key rather than per request.
+	verified sync.Map // prefix -> verifiedKey
+	// touched holds when each key ID last had its last_used_at written.
+	touched sync.Map // int -> time.Time
+}
+
+type verifiedKey struct {
+	keyHash string
+	digest  [sha256.Size]byte
+}
+
+func ApiKeysUsecase(apikeysRepository apikeysRepositories.IApiKeysRepository) IApiKeysUsecase {
+	return &apikeysUsecase{
+		apikeysRepository: apikeysRepository,
+	}
+}
+
+func (u *apikeysUsecase) ListApiKeys() ([]*apikeys.ApiKey, error) {
+	return u.apikeysRepository.List()
+}
+
+func (u *apikeysUsecase) GetApiKey(id int) (*apikeys.ApiKey, error) {
+	return u.apikeysRepository.GetByID(id)
+}
+
+// CreateApiKey issues a new key. The returned ApiKey carries the key in clear; it is the only
+// time it can be read.
+func (u *apikeysUsecase) CreateApiKey(req *apikeys.ApiKeyRequest, createdBy string) (*apikeys.ApiKey, error) {
+	scopes, err := validateScopes(req.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, apperrors.Validation("expires_at must be in the future")
+	}
+
+	prefix, key, keyHash, err := generateKey()
+	if err != nil {
+		return nil, err
+	}
+	id, err := u.apikeysRepository.Create(&apikeys.ApiKey{
+		Name:      strings.TrimSpace(req.Name),
+		Prefix:    prefix,
+		KeyHash:   keyHash,
+		Scopes:    scopes,
+		CreatedBy: createdBy,
+		ExpiresAt: req.ExpiresAt,
+	})
+	if err != nil {
+		return nil, apperrors.Internal(err, "Failed to create API key")
+	}
+
+	created, err := u.apikeysRepository.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	created.Key = key
+	return created, nil
+}
+
+// RotateApiKey replaces the key while keeping its name, scopes and expiry. The old key stops
+// working immediately.
+func (u *apikeysUsecase) RotateApiKey(id int) (*apikeys.ApiKey, error) {
+	current, err := u.apikeysRepository.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if !current.Active(time.Now()) {
+		return nil, apperrors.Conflict("API key with ID %d is revoked or expired", id)
+	}
+
+	prefix, key, keyHash, err := generateKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := u.apikeysRepository.Rotate(id, prefix, keyHash); err != nil {
+		return nil, err
+	}
+	u.verified.Delete(current.Prefix)
+
+	rotated, err := u.apikeysRepository.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	rotated.Key = key
+	return rotated, nil
+}
+
+func (u *apikeysUsecase) RevokeApiKey(id int) error {
+	key, err := u.apikeysRepository.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if err := u.apikeysRepository.Revoke(id); err != nil {
+		return err
+	}
+	u.verified.Delete(key.Prefix)
+	return nil
+}
+
+// AuthenticateKey checks key against its stored hash and returns the principal it stands for.
+// Revocation and expiry are read from the database on every call, so they take effect at once.
+func (u *apikeysUsecase) AuthenticateKey(ctx context.Context, key string) (*auth.Principal, error) {
+	invalid := apperrors.Unauthorized("Invalid API key")
+
+	prefix, ok := parseKey(key)
+	if !ok {
+		return nil, invalid
+	}
+	stored, err := u.apikeysRepository.GetByPrefix(prefix)
+	if err != nil {
+		if apperrors.Is(err, apperrors.KindNotFound) {
+			return nil, invalid
+		}
+		return nil, err
+	}
+	if !u.checkKey(stored, key) {
+		return nil, invalid
+	}
+	if stored.RevokedAt != nil {
+		return nil, apperrors.Unauthorized("API key is revoked")
+	}
+	now := time.Now()
+	if !stored.Active(now) {
+		return nil, apperrors.Unauthorized("API key has expired")
+	}
+
+	if last, ok := u.touched.Load(stored.ID); !ok || now.Sub(last.(time.Time)) >= touchEvery {
+		u.touched.Store(stored.ID, now)
+		if err := u.apikeysRepository.Touch(stored.ID); err != nil {
+			logger.From(ctx).Warn("record use of api key failed", "api_key_id", stored.ID, "err", err)
+		}
+	}
+
+	return &auth.Principal{
+		Kind:   auth.PrincipalAPIKey,
+		ID:     strconv.Itoa(stored.ID),
+		Name:   stored.Name,
+		Scopes: stored.Scopes,
+	}, nil
+}
+
+// checkKey compares key with the stored bcrypt hash, or with the SHA-256 digest remembered from
+// an earlier successful comparison against the same hash.
+func (u *apikeysUsecase) checkKey(stored *apikeys.ApiKey, key string) bool {
+	digest := sha256.Sum256([]byte(key))
+	if cached, ok := u.verified.Load(stored.Prefix); ok {
+		v := cached.(verifiedKey)
+		if v.keyHash == stored.KeyHash {
+			return subtle.ConstantTimeCompare(v.digest[:], digest[:]) == 1
+		}
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(stored.KeyHash), []byte(key)) != nil {
+		return false
+	}
+	u.verified.Store(stored.Prefix, verifiedKey{keyHash: stored.KeyHash, digest: digest})
+	return true
+}
+
+// generateKey returns a fresh key, its lookup prefix and its bcrypt hash.
+func generateKey() (prefix, key, keyHash string, err error) {
+	id := make([]byte, 6)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", "", apperrors.Internal(err, "Failed to generate API key")
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", "", apperrors.Internal(err, "Failed to generate API key")
+	}
+
+	prefix = hex.EncodeToString(id)
+	key = keyPrefix + prefix + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	hash, err := bcrypt.GenerateFromPassword([]byte(key), bcrypt.DefaultCost)
+	if err != nil {
+		return "", "", "", apperrors.Internal(err, "Failed to hash API key")
+	}
+	return prefix, key, string(hash), nil
+}
+
+// parseKey extracts the lookup prefix of a "bk_<prefix>_<secret>" key.
+func parseKey(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, keyPrefix)
+	if !ok {
+		return "", false
+	}
+	prefix, secret, ok := strings.Cut(rest, "_")
+	if !ok || len(prefix) != 12 || secret == "" {
+		return "", false
+	}
+	return prefix, true
+}
+
+// validateScopes rejects unknown scopes and drops duplicates.
+func validateScopes(requested []string) (apikeys.Scopes, error) {
+	scopes := make(apikeys.Scopes, 0, len(requested))
+	seen := make(map[string]bool, len(requested))
+	for _, scope := range requested {
+		scope = strings.TrimSpace(scope)
+		known := false
+		for _, s := range auth.KnownScopes {
+			known = known || s == scope
+		}
+		if !known {
+			return nil, apperrors.Validation("Unknown scope %q", scope).With("known_scopes", auth.KnownScopes)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, nil
+}
@@ -0,0 +1,110 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/peedans/beerleo/pkg/auth"
+	"github.com/peedans/beerleo/pkg/requestinfo"
+	"time"
+)
+
+// Actions recorded for beers.
+const (
+	ActionBeerCreate  = "beer.create"
+	ActionBeerUpdate  = "beer.update"
+	ActionBeerDelete  = "beer.delete"
+	ActionBeerRestore = "beer.restore"
+	ActionBeerPurge   = "beer.purge"
+)
+
+// Entry is one recorded change. Before and After are JSON snapshots of the beer; Before is
+// null for creations and After for purges.
+type Entry struct {
+	ID        int             `db:"id" json:"id"`
+	ActorKind string          `db:"actor_kind" json:"actor_kind"`
+	ActorID   string          `db:"actor_id" json:"actor_id"`
+	ActorName string          `db:"actor_name" json:"actor_name"`
+	Action    string          `db:"action" json:"action"`
+	BeerID    *int            `db:"beer_id" json:"beer_id"`
+	RequestID string          `db:"request_id" json:"request_id"`
+	ClientIP  string          `db:"client_ip" json:"client_ip"`
+	Before    json.RawMessage `db:"before_json" json:"before"`
+	After     json.RawMessage `db:"after_json" json:"after"`
+	CreatedAt *time.Time      `db:"created_at" json:"created_at"`
+}
+
+// Actor is who made a change and through which request, as recorded on its entries. Repositories
+// that write entries for a change are handed the actor, since they do not see the request context.
+type Actor struct {
+	Kind      string
+	ID        string
+	Name      string
+	RequestID string
+	ClientIP  string
+}
+
+// ActorFrom returns the actor of the principal and request carried by ctx.
+func ActorFrom(ctx context.Context) *Actor {
+	actor := &Actor{}
+	if principal, ok := auth.PrincipalFromContext(ctx); ok {
+		actor.Kind, actor.ID, actor.Name = principal.Kind, principal.ID, principal.Name
+	}
+	info := requestinfo.From(ctx)
+	actor.RequestID, actor.ClientIP = info.ID, info.ClientIP
+	return actor
+}
+
+// NewEntry describes action on a beer by the principal and request carried by ctx. before and
+// after are marshalled to JSON; nil leaves the snapshot empty.
+func NewEntry(ctx context.Context, action string, beerID int, before, after interface{}) (*Entry, error) {
+	return ActorFrom(ctx).Entry(action, beerID, before, after)
+}
+
+// Entry describes action on a beer by the actor, like NewEntry.
+func (a *Actor) Entry(action string, beerID int, before, after interface{}) (*Entry, error) {
+	entry := &Entry{
+		ActorKind: a.Kind,
+		ActorID:   a.ID,
+		ActorName: a.Name,
+		Action:    action,
+		BeerID:    &beerID,
+		RequestID: a.RequestID,
+		ClientIP:  a.ClientIP,
+	}
+
+	var err error
+	if entry.Before, err = snapshot(before); err != nil {
+		return nil, err
+	}
+	if entry.After, err = snapshot(after); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// snapshot marshals value, turning nil (typed or not) into a NULL column rather than JSON null.
+func snapshot(value interface{}) (json.RawMessage, error) {
+	raw, err := json.Marshal(value)
+	if err != nil || string(raw) == "null" {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// Filter narrows the audit log. Zero-valued fields do not filter; From and To are inclusive.
+type Filter struct {
+	ActorID string
+	Action  string
+	BeerID  int
+	From    *time.Time
+	To      *time.Time
+	Page    int
+	Limit   int
+}
+
+type PagingResult struct {
+	Page      int `json:"page"`
+	Limit     int `json:"limit"`
+	Count     int `json:"count"`
+	TotalPage int `json:"totalPage"`
+}
@@ -0,0 +1,133 @@
+package auditHandlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/peedans/beerleo/modules/audit"
+	"github.com/peedans/beerleo/modules/audit/auditUsecases"
+	"github.com/peedans/beerleo/pkg/apperrors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultLimit = 50
+	maxLimit     = 200
+)
+
+type IAuditHandler interface {
+	ListEntries(c *gin.Context)
+}
+
+type auditHandler struct {
+	auditUsecase auditUsecases.IAuditUsecase
+}
+
+func AuditHandler(auditUsecase auditUsecases.IAuditUsecase) IAuditHandler {
+	return &auditHandler{
+		auditUsecase: auditUsecase,
+	}
+}
+
+// ListEntries returns the audit log, newest first. It filters by actor (the user subject or API
+// key ID), action, beer_id and from/to (RFC 3339 or YYYY-MM-DD, inclusive), and pages with
+// page and limit.
+func (h *auditHandler) ListEntries(c *gin.Context) {
+	filter, err := getFilter(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	entries, total, err := h.auditUsecase.ListEntries(c.Request.Context(), filter)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.Header("X-Total-Count", strconv.Itoa(total))
+	c.JSON(http.StatusOK, gin.H{
+		"data": entries,
+		"pagination": &audit.PagingResult{
+			Page:      filter.Page,
+			Limit:     filter.Limit,
+			Count:     total,
+			TotalPage: (total + filter.Limit - 1) / filter.Limit,
+		},
+	})
+}
+
+func getFilter(c *gin.Context) (*audit.Filter, error) {
+	filter := &audit.Filter{
+		ActorID: strings.TrimSpace(c.Query("actor")),
+		Action:  strings.TrimSpace(c.Query("action")),
+		Page:    1,
+		Limit:   defaultLimit,
+	}
+	invalid := make(map[string]string)
+
+	if value := c.Query("beer_id"); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil || id <= 0 {
+			invalid["beer_id"] = "numeric"
+		}
+		filter.BeerID = id
+	}
+	if value := c.Query("page"); value != "" {
+		page, err := strconv.Atoi(value)
+		if err != nil || page < 1 || page > 1_000_000 {
+			invalid["page"] = "min=1"
+		}
+		filter.Page = page
+	}
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxLimit {
+			invalid["limit"] = "max=" + strconv.Itoa(maxLimit)
+		}
+		filter.Limit = limit
+	}
+
+	dates := []struct {
+		param  string
+		target **time.Time
+		endOf  bool
+	}{
+		{"from", &filter.From, false},
+		{"to", &filter.To, true},
+	}
+	for _, date := range dates {
+		value := c.Query(date.param)
+		if value == "" {
+			continue
+		}
+		t, err := parseDateParam(value, date.endOf)
+		if err != nil {
+			invalid[date.param] = "datetime"
+			continue
+		}
+		*date.target = &t
+	}
+
+	if len(invalid) > 0 {
+		return nil, apperrors.Validation("Invalid filter parameters").With("fields", invalid)
+	}
+	return filter, nil
+}
+
+// parseDateParam accepts RFC 3339 timestamps or plain dates. A plain date used as an upper
+// bound covers the whole day.
+func parseDateParam(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Second)
+	}
+	return t, nil
+}
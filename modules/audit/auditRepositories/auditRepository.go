@@ -0,0 +1,72 @@
+package auditRepositories
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/peedans/beerleo/modules/audit"
+	"github.com/peedans/beerleo/pkg/apperrors"
+	"github.com/peedans/beerleo/pkg/metrics"
+	"strings"
+)
+
+// repositoryName labels the query duration metrics of this repository.
+const repositoryName = "audit"
+
+type IAuditRepository interface {
+	List(filter *audit.Filter) ([]*audit.Entry, int, error)
+}
+
+type auditRepository struct {
+	db *sqlx.DB
+}
+
+func AuditRepository(db *sqlx.DB) IAuditRepository {
+	return &auditRepository{
+		db: db,
+	}
+}
+
+// List returns one page of entries matching filter, newest first, and the number of matches.
+func (r *auditRepository) List(filter *audit.Filter) ([]*audit.Entry, int, error) {
+	defer metrics.ObserveQuery(repositoryName, "List")()
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	if filter.ActorID != "" {
+		conditions = append(conditions, "actor_id = ?")
+		args = append(args, filter.ActorID)
+	}
+	if filter.Action != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, filter.Action)
+	}
+	if filter.BeerID != 0 {
+		conditions = append(conditions, "beer_id = ?")
+		args = append(args, filter.BeerID)
+	}
+	if filter.From != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, *filter.From)
+	}
+	if filter.To != nil {
+		conditions = append(conditions, "created_at <= ?")
+		args = append(args, *filter.To)
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := r.db.Get(&total, "SELECT COUNT(*) FROM audit_log"+where, args...); err != nil {
+		return nil, 0, apperrors.Internal(err, "Failed to count audit log entries")
+	}
+
+	entries := make([]*audit.Entry, 0)
+	query := "SELECT * FROM audit_log" + where + " ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?"
+	args = append(args, filter.Limit, (filter.Page-1)*filter.Limit)
+	if err := r.db.Select(&entries, query, args...); err != nil {
+		return nil, 0, apperrors.Internal(err, "Failed to list audit log entries")
+	}
+	return entries, total, nil
+}
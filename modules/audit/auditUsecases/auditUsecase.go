@@ -0,0 +1,29 @@
+package auditUsecases
+
+import (
+	"context"
+	"github.com/peedans/beerleo/modules/audit"
+	"github.com/peedans/beerleo/modules/audit/auditRepositories"
+	"github.com/peedans/beerleo/pkg/auth"
+)
+
+type IAuditUsecase interface {
+	ListEntries(ctx context.Context, filter *audit.Filter) ([]*audit.Entry, int, error)
+}
+
+type auditUsecase struct {
+	auditRepository auditRepositories.IAuditRepository
+}
+
+func AuditUsecase(auditRepository auditRepositories.IAuditRepository) IAuditUsecase {
+	return &auditUsecase{
+		auditRepository: auditRepository,
+	}
+}
+
+func (u *auditUsecase) ListEntries(ctx context.Context, filter *audit.Filter) ([]*audit.Entry, int, error) {
+	if err := auth.Authorize(ctx, auth.PermAuditRead); err != nil {
+		return nil, 0, err
+	}
+	return u.auditRepository.List(filter)
+}
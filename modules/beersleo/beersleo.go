@@ -1,40 +1,195 @@
 package beersleo
 
 import (
+	"fmt"
+	"github.com/peedans/beerleo/pkg/uploads"
 	"mime/multipart"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type Beersleo struct {
-	ID        int        `db:"id" json:"id"`
-	Name      string     `db:"name" json:"name"`
-	Category  string     `db:"category" json:"category"`
-	Detail    string     `db:"detail" json:"detail"`
-	Image     string     `db:"image" json:"image"`
-	CreatedAt *time.Time `db:"created_at" json:"created_at"`
-	UpdatedAt *time.Time `db:"updated_at" json:"updated_at"`
-	DeletedAt *time.Time `db:"deleted_at" json:"deleted_at,omitempty"`
+	ID   int    `db:"id" json:"id"`
+	Name string `db:"name" json:"name"`
+	// Category is a copy of the category's name; CategoryID is the reference that counts.
+	Category   string `db:"category" json:"category"`
+	CategoryID *int   `db:"category_id" json:"category_id"`
+	Detail     string `db:"detail" json:"detail"`
+	Image      string `db:"image" json:"image"`
+	// Images holds per-size URLs ("128", "512", "original"); it is filled in by the handler.
+	Images    map[string]string `db:"-" json:"images,omitempty"`
+	Version   int               `db:"version" json:"version"`
+	CreatedAt *time.Time        `db:"created_at" json:"created_at"`
+	UpdatedAt *time.Time        `db:"updated_at" json:"updated_at"`
+	DeletedAt *time.Time        `db:"deleted_at" json:"deleted_at,omitempty"`
 }
 
+// BeerRevision is a beer's editable fields as they were at version Revision, saved just before
+// an update replaced them.
+type BeerRevision struct {
+	ID         int    `db:"id" json:"-"`
+	BeerID     int    `db:"beer_id" json:"beer_id"`
+	Revision   int    `db:"revision" json:"revision"`
+	Name       string `db:"name" json:"name"`
+	Category   string `db:"category" json:"category"`
+	CategoryID *int   `db:"category_id" json:"category_id"`
+	Detail     string `db:"detail" json:"detail"`
+	Image      string `db:"image" json:"image"`
+	// Images holds per-size URLs like Beersleo.Images; it is filled in by the handler.
+	Images    map[string]string `db:"-" json:"images,omitempty"`
+	CreatedBy string            `db:"created_by" json:"created_by"`
+	CreatedAt *time.Time        `db:"created_at" json:"created_at"`
+}
+
+// NewBeerRevision snapshots the revisioned fields of beer.
+func NewBeerRevision(beer *Beersleo, createdBy string) *BeerRevision {
+	return &BeerRevision{
+		BeerID:     beer.ID,
+		Revision:   beer.Version,
+		Name:       beer.Name,
+		Category:   beer.Category,
+		CategoryID: beer.CategoryID,
+		Detail:     beer.Detail,
+		Image:      beer.Image,
+		CreatedBy:  createdBy,
+	}
+}
+
+// FieldDiff is one field that differs between a revision and the current beer.
+type FieldDiff struct {
+	Revision interface{} `json:"revision"`
+	Current  interface{} `json:"current"`
+}
+
+// BeerRevisionDiff is a revision compared with the current beer. Diff holds only the fields
+// that differ, by JSON name.
+type BeerRevisionDiff struct {
+	Revision *BeerRevision        `json:"revision"`
+	Current  *Beersleo            `json:"current"`
+	Diff     map[string]FieldDiff `json:"diff"`
+}
+
+// DiffRevision compares the revisioned fields of rev with beer.
+func DiffRevision(rev *BeerRevision, beer *Beersleo) map[string]FieldDiff {
+	diff := make(map[string]FieldDiff)
+	strs := []struct {
+		field         string
+		revision, now string
+	}{
+		{"name", rev.Name, beer.Name},
+		{"category", rev.Category, beer.Category},
+		{"detail", rev.Detail, beer.Detail},
+	}
+	for _, f := range strs {
+		if f.revision != f.now {
+			diff[f.field] = FieldDiff{Revision: f.revision, Current: f.now}
+		}
+	}
+	// Older rows hold full image URLs, so images are compared by storage key.
+	if ImageKey(rev.Image) != ImageKey(beer.Image) {
+		diff["image"] = FieldDiff{Revision: rev.Image, Current: beer.Image}
+	}
+	if !equalIntPtr(rev.CategoryID, beer.CategoryID) {
+		diff["category_id"] = FieldDiff{Revision: rev.CategoryID, Current: beer.CategoryID}
+	}
+	return diff
+}
+
+func equalIntPtr(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// BeersleoFilter narrows and orders the beer list. Zero-valued fields do not filter.
 type BeersleoFilter struct {
-	Name string `query:"name"`
+	// Query is a full-text search over name, category and detail.
+	Query  string
+	Name   string
+	Detail string
+	// Categories holds category slugs or names and CategoryIDs category IDs; both also match subcategories.
+	Categories     []string
+	CategoryIDs    []int
+	CreatedFrom    *time.Time
+	CreatedTo      *time.Time
+	UpdatedFrom    *time.Time
+	UpdatedTo      *time.Time
+	HasImage       *bool
+	Sort           []SortField
+	IncludeDeleted bool
+	Page           int
+	Limit          int
+	// SkipCount leaves out the COUNT(*) query behind the total, which is costly on large tables.
+	SkipCount bool
+	// Keyset switches from page/offset to keyset pagination when set.
+	Keyset *Keyset
+}
+
+// Window returns the LIMIT and OFFSET of the filter's page. Without a count one row past the
+// page is fetched as well, so callers can tell whether another page follows.
+func (f *BeersleoFilter) Window() (limit, offset int) {
+	limit, offset = f.Limit, (f.Page-1)*f.Limit
+	if f.SkipCount {
+		limit++
+	}
+	return limit, offset
+}
+
+// Keyset positions a cursor page: rows strictly after (or, when Backward, before) the row
+// whose sort key is Values. Values hold one entry per field of the normalized sort.
+type Keyset struct {
+	Values   []interface{}
+	Backward bool
+}
+
+// BeerSearchResult is a beer matched by a full-text search with its relevance and the
+// highlighted snippets of the fields that matched.
+type BeerSearchResult struct {
+	Beersleo
+	Score      float64           `db:"score" json:"score"`
+	Highlights map[string]string `db:"-" json:"highlights,omitempty"`
+}
+
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// SortableFields are the fields the list may be ordered by.
+var SortableFields = map[string]bool{
+	"id":         true,
+	"name":       true,
+	"category":   true,
+	"created_at": true,
+	"updated_at": true,
 }
 
 type BeerDTO struct {
-	ID       int
-	Name     string `form:"name" binding:"required"`
-	Category string `form:"category" binding:"required"`
-	Detail   string `form:"detail" binding:"required"`
-	Image    string `form:"image"`
+	ID         int
+	Name       string `form:"name" binding:"required"`
+	Category   string `form:"category" binding:"required"`
+	CategoryID *int   `db:"category_id"`
+	Detail     string `form:"detail" binding:"required"`
+	Image      string `form:"image"`
+}
+
+// BeerleoCursorResult is the paging block of a cursor-paginated response.
+type BeerleoCursorResult struct {
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"nextCursor,omitempty"`
+	PrevCursor string `json:"prevCursor,omitempty"`
 }
 
 type BeerleoPagingResult struct {
-	Page      int `json:"page"`
-	Limit     int `json:"limit"`
-	PrevPage  int `json:"prevPage"`
-	NextPage  int `json:"nextPage"`
-	Count     int `json:"count"`
-	TotalPage int `json:"totalPage"`
+	Page     int `json:"page"`
+	Limit    int `json:"limit"`
+	PrevPage int `json:"prevPage"`
+	NextPage int `json:"nextPage"`
+	// Count and TotalPage are left out when the request disabled counting with count=false.
+	Count     *int `json:"count,omitempty"`
+	TotalPage *int `json:"totalPage,omitempty"`
 }
 
 type BeerCreationRequest struct {
@@ -45,8 +200,164 @@ type BeerCreationRequest struct {
 }
 
 type UpdateBeer struct {
-	Name     string                `form:"name"`
-	Category string                `form:"category"`
-	Detail   string                `form:"detail"`
-	Image    *multipart.FileHeader `form:"image"`
+	Name        string                `form:"name" binding:"required"`
+	Category    string                `form:"category" binding:"required"`
+	Detail      string                `form:"detail" binding:"required"`
+	Image       *multipart.FileHeader `form:"image"`
+	RemoveImage bool                  `form:"remove_image"`
+}
+
+// BeerPatch lists the changes of a partial update; nil fields are left untouched.
+type BeerPatch struct {
+	Name        *string
+	Category    *string
+	Detail      *string
+	RemoveImage bool
+	// Version is the version the client expects to change (from If-Match); 0 skips the check.
+	Version int
+}
+
+// BeerImportRow is one record of a bulk import. Row is its 1-based position among the records
+// and Line, when known, where it starts in the file. Rows with Errors are reported and skipped.
+type BeerImportRow struct {
+	Row      int
+	Line     int
+	Name     string
+	Category string
+	Detail   string
+	Image    *uploads.Image
+	Errors   map[string]string
+	// CategoryID is filled in once Category has been resolved.
+	CategoryID int
+}
+
+type BeerImportOptions struct {
+	// DryRun validates and reports what would happen without writing anything.
+	DryRun bool
+	// Upsert updates the existing beer with the same name instead of adding another one.
+	Upsert bool
+	// ChunkSize is the number of rows written per transaction.
+	ChunkSize int
+}
+
+// Statuses of a row in a BeerImportReport. In a dry run created and updated mean "would be".
+const (
+	ImportCreated = "created"
+	ImportUpdated = "updated"
+	ImportFailed  = "failed"
+)
+
+type BeerImportReport struct {
+	DryRun  bool                `json:"dry_run"`
+	Upsert  bool                `json:"upsert"`
+	Total   int                 `json:"total"`
+	Created int                 `json:"created"`
+	Updated int                 `json:"updated"`
+	Failed  int                 `json:"failed"`
+	Rows    []*BeerImportResult `json:"rows"`
+}
+
+type BeerImportResult struct {
+	Row    int               `json:"row"`
+	Line   int               `json:"line,omitempty"`
+	Name   string            `json:"name"`
+	Status string            `json:"status"`
+	ID     int               `json:"id,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// NormalizeSort falls back to defaults when sort is empty and appends id as the final
+// tie-breaker, giving every row a unique position for keyset pagination.
+func NormalizeSort(sort []SortField, defaults ...SortField) []SortField {
+	if len(sort) == 0 {
+		sort = defaults
+	}
+	normalized := make([]SortField, 0, len(sort)+1)
+	for _, field := range sort {
+		normalized = append(normalized, field)
+		if field.Field == "id" {
+			return normalized
+		}
+	}
+	return append(normalized, SortField{Field: "id"})
+}
+
+// SortSpec renders a sort as the sort query parameter would spell it, e.g. "-created_at,id".
+func SortSpec(sort []SortField) string {
+	parts := make([]string, 0, len(sort))
+	for _, field := range sort {
+		if field.Desc {
+			parts = append(parts, "-"+field.Field)
+		} else {
+			parts = append(parts, field.Field)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// SortValues returns the beer's sort key as strings, for embedding in a cursor.
+// score is the search relevance and is only used when sorting by "score".
+func SortValues(beer *Beersleo, score float64, sort []SortField) []string {
+	values := make([]string, 0, len(sort))
+	for _, field := range sort {
+		switch field.Field {
+		case "id":
+			values = append(values, strconv.Itoa(beer.ID))
+		case "name":
+			values = append(values, beer.Name)
+		case "category":
+			values = append(values, beer.Category)
+		case "created_at":
+			values = append(values, formatTime(beer.CreatedAt))
+		case "updated_at":
+			values = append(values, formatTime(beer.UpdatedAt))
+		case "score":
+			values = append(values, strconv.FormatFloat(score, 'f', 6, 64))
+		}
+	}
+	return values
+}
+
+// ParseSortValues converts cursor strings back into typed values for the given sort.
+func ParseSortValues(sort []SortField, values []string) ([]interface{}, error) {
+	if len(values) != len(sort) {
+		return nil, fmt.Errorf("cursor has %d values for %d sort fields", len(values), len(sort))
+	}
+
+	parsed := make([]interface{}, len(values))
+	for i, field := range sort {
+		var err error
+		switch field.Field {
+		case "id":
+			parsed[i], err = strconv.Atoi(values[i])
+		case "created_at", "updated_at":
+			parsed[i], err = time.Parse(time.RFC3339Nano, values[i])
+		case "score":
+			// Kept as text so the database reads the exact decimal it returned.
+			_, err = strconv.ParseFloat(values[i], 64)
+			parsed[i] = values[i]
+		default:
+			parsed[i] = values[i]
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return parsed, nil
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// ImageKey returns the storage key for a beer's image column. Older rows stored the full
+// URL (http://host/uploads/beers/<id>/<file>), newer rows store the key itself.
+func ImageKey(image string) string {
+	if i := strings.Index(image, "/uploads/"); i >= 0 {
+		return image[i+len("/uploads/"):]
+	}
+	return strings.TrimLeft(image, "/")
 }
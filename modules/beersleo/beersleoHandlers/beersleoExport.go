@@ -0,0 +1,228 @@
+package beersleoHandlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/peedans/beerleo/modules/beersleo"
+	"github.com/peedans/beerleo/pkg/apperrors"
+	"github.com/peedans/beerleo/pkg/logger"
+	"github.com/peedans/beerleo/pkg/spreadsheets"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// exportColumns are the columns of CSV and XLSX exports, in order.
+var exportColumns = []string{"id", "name", "category", "category_id", "detail", "image", "version", "created_at", "updated_at", "deleted_at"}
+
+// exportFlushEvery is how many rows are buffered before they are pushed to the client.
+const exportFlushEvery = 100
+
+// beerExporter encodes beers in one export format.
+type beerExporter interface {
+	Write(beer *beersleo.Beersleo) error
+	Flush() error
+	Close() error
+}
+
+var exportContentTypes = map[string]string{
+	"csv":    "text/csv; charset=utf-8",
+	"ndjson": "application/x-ndjson",
+	"xlsx":   "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// ExportBeers streams every beer matching the list filters as format=csv (default), ndjson or
+// xlsx. Rows go out as they are read from the database, so the catalog is never held in memory.
+func (h *beersleoHandler) ExportBeers(c *gin.Context) {
+	format := c.DefaultQuery("format", "csv")
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		_ = c.Error(apperrors.Validation("Invalid format %q, must be csv, ndjson or xlsx", format))
+		return
+	}
+
+	includeDeleted, err := getIncludeDeleted(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	filter, err := getBeerFilter(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	filter.IncludeDeleted = includeDeleted
+
+	// Output starts with the first row, so a failing query still gets a problem+json response.
+	var exporter beerExporter
+	start := func() error {
+		filename := "beers-" + time.Now().UTC().Format("20060102-150405") + "." + format
+		c.Header("Content-Type", contentType)
+		c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+		c.Status(http.StatusOK)
+
+		exporter, err = newBeerExporter(format, c.Writer)
+		return err
+	}
+
+	rows := 0
+	err = h.beersleoUsecase.ExportBeers(c.Request.Context(), filter, func(beer *beersleo.Beersleo) error {
+		if exporter == nil {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		h.setImageURLs(c, beer)
+		if err := exporter.Write(beer); err != nil {
+			return err
+		}
+		if rows++; rows%exportFlushEvery == 0 {
+			if err := exporter.Flush(); err != nil {
+				return err
+			}
+			c.Writer.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		if exporter == nil {
+			_ = c.Error(err)
+			return
+		}
+		// The response is already under way; all that is left is to cut it short.
+		logger.From(c.Request.Context()).Error("export beers failed", "rows", rows, "err", err)
+		return
+	}
+
+	if exporter == nil {
+		if err := start(); err != nil {
+			_ = c.Error(err)
+			return
+		}
+	}
+	if err := exporter.Close(); err != nil {
+		logger.From(c.Request.Context()).Error("export beers failed", "rows", rows, "err", err)
+	}
+}
+
+func newBeerExporter(format string, w io.Writer) (beerExporter, error) {
+	switch format {
+	case "ndjson":
+		buffered := bufio.NewWriter(w)
+		return &ndjsonExporter{w: buffered, encoder: json.NewEncoder(buffered)}, nil
+	case "xlsx":
+		xlsx, err := spreadsheets.NewXLSXWriter(w, "Beers")
+		if err != nil {
+			return nil, err
+		}
+		header := make([]interface{}, len(exportColumns))
+		for i, column := range exportColumns {
+			header[i] = column
+		}
+		if err := xlsx.WriteRow(header...); err != nil {
+			return nil, err
+		}
+		return &xlsxExporter{w: xlsx}, nil
+	default:
+		// The byte order mark makes Excel read the file as UTF-8, which Thai text needs.
+		if _, err := io.WriteString(w, "\xef\xbb\xbf"); err != nil {
+			return nil, err
+		}
+		writer := csv.NewWriter(w)
+		if err := writer.Write(exportColumns); err != nil {
+			return nil, err
+		}
+		return &csvExporter{w: writer}, nil
+	}
+}
+
+type csvExporter struct {
+	w *csv.Writer
+}
+
+func (e *csvExporter) Write(beer *beersleo.Beersleo) error {
+	categoryID := ""
+	if beer.CategoryID != nil {
+		categoryID = strconv.Itoa(*beer.CategoryID)
+	}
+	return e.w.Write([]string{
+		strconv.Itoa(beer.ID),
+		beer.Name,
+		beer.Category,
+		categoryID,
+		beer.Detail,
+		beer.Image,
+		strconv.Itoa(beer.Version),
+		exportTime(beer.CreatedAt),
+		exportTime(beer.UpdatedAt),
+		exportTime(beer.DeletedAt),
+	})
+}
+
+func (e *csvExporter) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvExporter) Close() error {
+	return e.Flush()
+}
+
+type ndjsonExporter struct {
+	w       *bufio.Writer
+	encoder *json.Encoder
+}
+
+func (e *ndjsonExporter) Write(beer *beersleo.Beersleo) error {
+	return e.encoder.Encode(beer)
+}
+
+func (e *ndjsonExporter) Flush() error {
+	return e.w.Flush()
+}
+
+func (e *ndjsonExporter) Close() error {
+	return e.w.Flush()
+}
+
+type xlsxExporter struct {
+	w *spreadsheets.XLSXWriter
+}
+
+func (e *xlsxExporter) Write(beer *beersleo.Beersleo) error {
+	var categoryID interface{}
+	if beer.CategoryID != nil {
+		categoryID = *beer.CategoryID
+	}
+	return e.w.WriteRow(
+		beer.ID,
+		beer.Name,
+		beer.Category,
+		categoryID,
+		beer.Detail,
+		beer.Image,
+		beer.Version,
+		exportTime(beer.CreatedAt),
+		exportTime(beer.UpdatedAt),
+		exportTime(beer.DeletedAt),
+	)
+}
+
+func (e *xlsxExporter) Flush() error {
+	return e.w.Flush()
+}
+
+func (e *xlsxExporter) Close() error {
+	return e.w.Close()
+}
+
+func exportTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
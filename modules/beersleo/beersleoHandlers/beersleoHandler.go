@@ -1,69 +1,263 @@
 package beersleoHandlers
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/peedans/beerleo/config"
 	"github.com/peedans/beerleo/modules/beersleo"
 	"github.com/peedans/beerleo/modules/beersleo/beersleoUsecases"
+	"github.com/peedans/beerleo/pkg/apperrors"
+	"github.com/peedans/beerleo/pkg/cursors"
+	"github.com/peedans/beerleo/pkg/metrics"
+	"github.com/peedans/beerleo/pkg/storages"
+	"github.com/peedans/beerleo/pkg/uploads"
+	"io"
+	"math"
 	"net/http"
-	"os"
+	"net/url"
+	"path"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type IBeersleoHandler interface {
 	GetBeerByID(c *gin.Context)
+	GetBeerImage(c *gin.Context)
 	DeleteBeer(c *gin.Context)
-	FilterBeersByName(c *gin.Context)
+	RestoreBeer(c *gin.Context)
 	GetAllBeersPagination(c *gin.Context)
+	SearchBeers(c *gin.Context)
 	UpdateBeer(c *gin.Context)
+	PatchBeer(c *gin.Context)
 	CreateBeer(c *gin.Context)
+	ImportBeers(c *gin.Context)
+	ExportBeers(c *gin.Context)
+	ListBeerRevisions(c *gin.Context)
+	GetBeerRevision(c *gin.Context)
+	GetBeerRevisionImage(c *gin.Context)
+	RevertBeer(c *gin.Context)
 }
 
 type beersleoHandler struct {
+	cfg             config.IBeerConfig
 	beersleoUsecase beersleoUsecases.IBeersleoUsecase
+	imageStore      storages.IImageStore
 }
 
-func BeersleoHandler(beersleoUsecase beersleoUsecases.IBeersleoUsecase) IBeersleoHandler {
+func BeersleoHandler(cfg config.IBeerConfig, beersleoUsecase beersleoUsecases.IBeersleoUsecase, imageStore storages.IImageStore) IBeersleoHandler {
 	return &beersleoHandler{
+		cfg:             cfg,
 		beersleoUsecase: beersleoUsecase,
+		imageStore:      imageStore,
 	}
 }
 
 func (h *beersleoHandler) GetBeerByID(c *gin.Context) {
-	idStr := c.Param("id")
-	id, err := strconv.Atoi(idStr)
+	id, err := getBeerID(c)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid beer ID"})
+		_ = c.Error(err)
 		return
 	}
 
-	beer, err := h.beersleoUsecase.GetBeerByID(id)
+	includeDeleted, err := getIncludeDeleted(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve beer"})
+		_ = c.Error(err)
 		return
 	}
+
+	beer, err := h.beersleoUsecase.GetBeerByID(c.Request.Context(), id, includeDeleted)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	etag := beerETag(beer)
+	c.Header("ETag", etag)
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	h.setImageURLs(c, beer)
 	c.JSON(http.StatusOK, beer)
 }
 
+// beerETag is the strong entity tag of a beer's current version.
+func beerETag(beer *beersleo.Beersleo) string {
+	return fmt.Sprintf(`"%d-%d"`, beer.ID, beer.Version)
+}
+
+// getIfMatchVersion returns the version named by the If-Match header, or 0 when the header is
+// absent or "*". A header that names no version of this beer fails the precondition.
+func getIfMatchVersion(c *gin.Context, id int) (int, error) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" || header == "*" {
+		return 0, nil
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		// If-Match uses strong comparison, so weak tags never match.
+		if strings.HasPrefix(tag, "W/") {
+			continue
+		}
+		var tagID, version int
+		if _, err := fmt.Sscanf(tag, `"%d-%d"`, &tagID, &version); err == nil && tagID == id && version > 0 {
+			return version, nil
+		}
+	}
+
+	return 0, apperrors.PreconditionFailed("If-Match does not match the current version of beer %d", id)
+}
+
+// GetBeerImage streams a beer's image from storage. http.ServeContent takes care of
+// conditional requests (If-None-Match / If-Modified-Since) and Range requests.
+func (h *beersleoHandler) GetBeerImage(c *gin.Context) {
+	id, err := getBeerID(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	beer, err := h.beersleoUsecase.GetBeerByID(c.Request.Context(), id, false)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if beer.Image == "" {
+		_ = c.Error(apperrors.NotFound("Beer %d has no image", id))
+		return
+	}
+
+	h.serveImage(c, beer.Image, apperrors.NotFound("Image of beer %d not found", id))
+}
+
+// serveImage streams the stored image, or the variant named by the size query option, and
+// reports notFound when it is missing from storage.
+func (h *beersleoHandler) serveImage(c *gin.Context, image string, notFound error) {
+	key, err := imageVariantKey(beersleo.ImageKey(image), c.Query("size"))
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	obj, err := h.imageStore.Get(key)
+	if errors.Is(err, storages.ErrObjectNotFound) {
+		_ = c.Error(notFound)
+		return
+	}
+	if err != nil {
+		_ = c.Error(apperrors.Internal(err, "Failed to read beer image"))
+		return
+	}
+	defer obj.Body.Close()
+
+	contentType, err := sniffContentType(obj.Body)
+	if err != nil {
+		_ = c.Error(apperrors.Internal(err, "Failed to read beer image"))
+		return
+	}
+
+	header := c.Writer.Header()
+	header.Set("Content-Type", contentType)
+	header.Set("Cache-Control", "public, max-age=3600")
+	header.Set("X-Content-Type-Options", "nosniff")
+	if obj.ETag != "" {
+		header.Set("ETag", obj.ETag)
+	}
+
+	http.ServeContent(c.Writer, c.Request, path.Base(key), obj.ModTime, obj.Body)
+}
+
+// imageVariantKey returns the key of the variant of the original image key named by the size
+// query option, or the original when size is empty or the image has no variants.
+func imageVariantKey(key, size string) (string, error) {
+	if size == "" {
+		return key, nil
+	}
+	n, err := strconv.Atoi(size)
+	if err != nil || !containsInt(uploads.VariantSizes, n) {
+		return "", apperrors.Validation("Invalid size, want one of %v", uploads.VariantSizes)
+	}
+	if !uploads.HasVariants(key) {
+		return key, nil
+	}
+	variant, _ := uploads.VariantKey(key, n)
+	return variant, nil
+}
+
+func containsInt(values []int, v int) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// sniffContentType detects the content type from the first bytes of body and rewinds it.
+func sniffContentType(body io.ReadSeeker) (string, error) {
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(body, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+// GetAllBeersPagination lists beers with page/limit paging, or with keyset paging when an
+// after or before cursor is given (an empty after= starts at the first page).
 func (h *beersleoHandler) GetAllBeersPagination(c *gin.Context) {
-	page, limit, err := getPaginationParams(c)
+	page, limit, err := h.getPaginationParams(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	includeDeleted, err := getIncludeDeleted(c)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		_ = c.Error(err)
 		return
 	}
 
-	beersData, total, err := h.beersleoUsecase.GetAllBeersPagination(page, limit)
+	filter, err := getBeerFilter(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve beers"})
+		_ = c.Error(err)
+		return
+	}
+	filter.IncludeDeleted = includeDeleted
+	filter.Page = page
+	filter.Limit = limit
+
+	if isCursorMode(c) {
+		h.listBeersByCursor(c, filter)
 		return
 	}
 
-	pagination, err := getPagination(c, total)
+	count, err := getCount(c)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		_ = c.Error(err)
 		return
 	}
+	filter.SkipCount = !count
+
+	beersData, total, err := h.beersleoUsecase.GetAllBeersPagination(c.Request.Context(), filter)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	for _, beer := range beersData {
+		h.setImageURLs(c, beer)
+	}
+
+	pagination := getPagination(page, limit, total, count)
+	setPageHeaders(c, pagination, total, count)
 
 	response := struct {
 		Data   []*beersleo.Beersleo          `json:"data"`
@@ -76,37 +270,445 @@ func (h *beersleoHandler) GetAllBeersPagination(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
-func getPaginationParams(c *gin.Context) (page, limit int, err error) {
+func (h *beersleoHandler) listBeersByCursor(c *gin.Context, filter *beersleo.BeersleoFilter) {
+	filter.Sort = beersleo.NormalizeSort(filter.Sort)
+	spec := "list:" + beersleo.SortSpec(filter.Sort)
+
+	keyset, err := h.getKeyset(c, filter.Sort, spec)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	filter.Keyset = keyset
+
+	beersData, hasMore, err := h.beersleoUsecase.GetAllBeersCursor(c.Request.Context(), filter)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	paging, err := h.cursorPaging(filter, spec, hasMore, len(beersData), func(i int) []string {
+		return beersleo.SortValues(beersData[i], 0, filter.Sort)
+	})
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	for _, beer := range beersData {
+		h.setImageURLs(c, beer)
+	}
+	setCursorHeaders(c, paging)
+
+	response := struct {
+		Data   []*beersleo.Beersleo          `json:"data"`
+		Paging *beersleo.BeerleoCursorResult `json:"paging"`
+	}{
+		Data:   beersData,
+		Paging: paging,
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func isCursorMode(c *gin.Context) bool {
+	_, after := c.GetQuery("after")
+	_, before := c.GetQuery("before")
+	return after || before
+}
+
+// getKeyset verifies the after/before cursor and converts it to a keyset for sort. spec names
+// the listing and sort the cursor must have been issued for. An empty after returns nil,
+// which starts at the beginning.
+func (h *beersleoHandler) getKeyset(c *gin.Context, sort []beersleo.SortField, spec string) (*beersleo.Keyset, error) {
+	after, before := c.Query("after"), c.Query("before")
+	if after != "" && before != "" {
+		return nil, apperrors.Validation("Only one of after and before may be given")
+	}
+
+	token, backward := after, false
+	if before != "" {
+		token, backward = before, true
+	}
+	if token == "" {
+		return nil, nil
+	}
+
+	cursor, err := cursors.Decode(h.cfg.CursorSecret(), token, time.Now())
+	if errors.Is(err, cursors.ErrExpiredCursor) {
+		return nil, apperrors.Validation("Cursor has expired, start again from the first page")
+	}
+	if err != nil || cursor.Sort != spec {
+		return nil, apperrors.Validation("Invalid cursor")
+	}
+	values, err := beersleo.ParseSortValues(sort, cursor.Values)
+	if err != nil {
+		return nil, apperrors.Validation("Invalid cursor")
+	}
+
+	return &beersleo.Keyset{Values: values, Backward: backward}, nil
+}
+
+// cursorPaging issues the next/prev cursors for a page of n rows; values returns the sort key
+// of row i. Going forward, a next cursor exists when more rows follow and a prev cursor when
+// the page did not start at the beginning; going backward the roles swap.
+func (h *beersleoHandler) cursorPaging(filter *beersleo.BeersleoFilter, spec string, hasMore bool, n int, values func(i int) []string) (*beersleo.BeerleoCursorResult, error) {
+	paging := &beersleo.BeerleoCursorResult{Limit: filter.Limit}
+	if n == 0 {
+		return paging, nil
+	}
+
+	backward := filter.Keyset != nil && filter.Keyset.Backward
+	hasNext := hasMore || backward
+	hasPrev := filter.Keyset != nil && !backward || backward && hasMore
+
+	var expires int64
+	if ttl := h.cfg.CursorTTL(); ttl > 0 {
+		expires = time.Now().Add(ttl).Unix()
+	}
+
+	var err error
+	if hasNext {
+		paging.NextCursor, err = cursors.Encode(h.cfg.CursorSecret(), &cursors.Cursor{Sort: spec, Values: values(n - 1), Expires: expires})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if hasPrev {
+		paging.PrevCursor, err = cursors.Encode(h.cfg.CursorSecret(), &cursors.Cursor{Sort: spec, Values: values(0), Expires: expires})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return paging, nil
+}
+
+// SearchBeers ranks beers by full-text relevance to q. The list filters may be combined with it.
+func (h *beersleoHandler) SearchBeers(c *gin.Context) {
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		_ = c.Error(apperrors.Validation("q query parameter is required"))
+		return
+	}
+
+	page, limit, err := h.getPaginationParams(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	filter, err := getBeerFilter(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	filter.Query = query
+	filter.Page = page
+	filter.Limit = limit
+
+	if isCursorMode(c) {
+		h.searchBeersByCursor(c, filter)
+		return
+	}
+
+	count, err := getCount(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	filter.SkipCount = !count
+
+	results, total, err := h.beersleoUsecase.SearchBeers(c.Request.Context(), filter)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	for _, result := range results {
+		h.setImageURLs(c, &result.Beersleo)
+	}
+
+	pagination := getPagination(page, limit, total, count)
+	setPageHeaders(c, pagination, total, count)
+
+	response := struct {
+		Data   []*beersleo.BeerSearchResult  `json:"data"`
+		Paging *beersleo.BeerleoPagingResult `json:"paging"`
+	}{
+		Data:   results,
+		Paging: pagination,
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *beersleoHandler) searchBeersByCursor(c *gin.Context, filter *beersleo.BeersleoFilter) {
+	filter.Sort = beersleo.NormalizeSort(filter.Sort, beersleo.SortField{Field: "score", Desc: true})
+	spec := "search:" + filter.Query + ":" + beersleo.SortSpec(filter.Sort)
+
+	keyset, err := h.getKeyset(c, filter.Sort, spec)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	filter.Keyset = keyset
+
+	results, hasMore, err := h.beersleoUsecase.SearchBeersCursor(c.Request.Context(), filter)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	paging, err := h.cursorPaging(filter, spec, hasMore, len(results), func(i int) []string {
+		return beersleo.SortValues(&results[i].Beersleo, results[i].Score, filter.Sort)
+	})
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	for _, result := range results {
+		h.setImageURLs(c, &result.Beersleo)
+	}
+	setCursorHeaders(c, paging)
+
+	response := struct {
+		Data   []*beersleo.BeerSearchResult  `json:"data"`
+		Paging *beersleo.BeerleoCursorResult `json:"paging"`
+	}{
+		Data:   results,
+		Paging: paging,
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// maxOffset keeps (page-1)*limit well inside the range MySQL accepts for OFFSET.
+const maxOffset = math.MaxInt32
+
+// getPaginationParams reads page and limit. limit defaults to and must stay within the
+// configured page limits; page starts at 1.
+func (h *beersleoHandler) getPaginationParams(c *gin.Context) (page, limit int, err error) {
 	pageStr := c.DefaultQuery("page", "1")
-	limitStr := c.DefaultQuery("limit", "10")
+	limitStr := c.DefaultQuery("limit", strconv.Itoa(h.cfg.DefaultPageLimit()))
 
 	page, err = strconv.Atoi(pageStr)
-	if err != nil {
-		return 0, 0, fmt.Errorf("Invalid page number")
+	if err != nil || page < 1 {
+		return 0, 0, apperrors.Validation("Invalid page number, page must be a positive integer")
 	}
 	limit, err = strconv.Atoi(limitStr)
-	if err != nil {
-		return 0, 0, fmt.Errorf("Invalid limit number")
+	if err != nil || limit < h.cfg.MinPageLimit() || limit > h.cfg.MaxPageLimit() {
+		return 0, 0, apperrors.Validation("Invalid limit number, limit must be between %d and %d",
+			h.cfg.MinPageLimit(), h.cfg.MaxPageLimit())
+	}
+	if page-1 > maxOffset/limit {
+		return 0, 0, apperrors.Validation("Page %d is out of range", page)
 	}
 
 	return page, limit, nil
 }
 
-func getPagination(c *gin.Context, total int) (*beersleo.BeerleoPagingResult, error) {
-	page, limit, err := getPaginationParams(c)
+// getCount reads the count option; count=false skips counting the total, which also drops
+// the count fields, X-Total-Count and the last link from the response.
+func getCount(c *gin.Context) (bool, error) {
+	value := c.Query("count")
+	if value == "" {
+		return true, nil
+	}
+	count, err := strconv.ParseBool(value)
 	if err != nil {
-		return nil, err
+		return false, apperrors.Validation("Invalid count value %q", value)
 	}
+	return count, nil
+}
+
+// getIncludeDeleted reads the include_deleted query option used by admins to see soft-deleted beers.
+// The usecases only grant it to callers holding the beers:restore permission.
+func getIncludeDeleted(c *gin.Context) (bool, error) {
+	includeDeleted, err := strconv.ParseBool(c.DefaultQuery("include_deleted", "false"))
+	if err != nil {
+		return false, apperrors.Validation("Invalid include_deleted value")
+	}
+	return includeDeleted, nil
+}
+
+// getBeerFilter reads the list filters: name and detail (contains), category (name or slug) and
+// category_id, which include subcategories (repeat the parameter or separate values with commas), created_from/created_to and updated_from/updated_to
+// (RFC 3339 or YYYY-MM-DD, inclusive), has_image, and sort (e.g. sort=-created_at,name).
+func getBeerFilter(c *gin.Context) (*beersleo.BeersleoFilter, error) {
+	filter := &beersleo.BeersleoFilter{
+		Name:   strings.TrimSpace(c.Query("name")),
+		Detail: strings.TrimSpace(c.Query("detail")),
+	}
+
+	for _, value := range c.QueryArray("category") {
+		for _, category := range strings.Split(value, ",") {
+			if category = strings.TrimSpace(category); category != "" {
+				filter.Categories = append(filter.Categories, category)
+			}
+		}
+	}
+
+	invalid := make(map[string]string)
+	for _, value := range c.QueryArray("category_id") {
+		for _, idStr := range strings.Split(value, ",") {
+			if idStr = strings.TrimSpace(idStr); idStr == "" {
+				continue
+			}
+			id, err := strconv.Atoi(idStr)
+			if err != nil || id <= 0 {
+				invalid["category_id"] = "numeric"
+				continue
+			}
+			filter.CategoryIDs = append(filter.CategoryIDs, id)
+		}
+	}
+
+	dates := []struct {
+		param  string
+		target **time.Time
+		endOf  bool
+	}{
+		{"created_from", &filter.CreatedFrom, false},
+		{"created_to", &filter.CreatedTo, true},
+		{"updated_from", &filter.UpdatedFrom, false},
+		{"updated_to", &filter.UpdatedTo, true},
+	}
+	for _, date := range dates {
+		value := c.Query(date.param)
+		if value == "" {
+			continue
+		}
+		t, err := parseDateParam(value, date.endOf)
+		if err != nil {
+			invalid[date.param] = "datetime"
+			continue
+		}
+		*date.target = &t
+	}
+
+	if value := c.Query("has_image"); value != "" {
+		hasImage, err := strconv.ParseBool(value)
+		if err != nil {
+			invalid["has_image"] = "boolean"
+		} else {
+			filter.HasImage = &hasImage
+		}
+	}
+
+	if value := c.Query("sort"); value != "" {
+		for _, field := range strings.Split(value, ",") {
+			field = strings.TrimSpace(field)
+			sortField := beersleo.SortField{Field: strings.TrimPrefix(field, "-"), Desc: strings.HasPrefix(field, "-")}
+			if !beersleo.SortableFields[sortField.Field] {
+				invalid["sort"] = "oneof=id name category created_at updated_at"
+				break
+			}
+			filter.Sort = append(filter.Sort, sortField)
+		}
+	}
+
+	if len(invalid) > 0 {
+		return nil, apperrors.Validation("Invalid filter parameters").With("fields", invalid)
+	}
+	return filter, nil
+}
+
+// parseDateParam accepts RFC 3339 timestamps or plain dates. A plain date used as an upper
+// bound covers the whole day.
+func parseDateParam(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Second)
+	}
+	return t, nil
+}
+
+func getBeerID(c *gin.Context) (int, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		return 0, apperrors.Validation("Invalid beer ID")
+	}
+	return id, nil
+}
+
+// getPagination describes the page. When counted is false, total is only the lower bound
+// returned by the usecase, which is enough to tell whether a next page exists.
+func getPagination(page, limit, total int, counted bool) *beersleo.BeerleoPagingResult {
+	pagination := &beersleo.BeerleoPagingResult{
+		Page:     page,
+		Limit:    limit,
+		PrevPage: max(1, page-1),
+	}
+
+	if !counted {
+		pagination.NextPage = page
+		if total > page*limit {
+			pagination.NextPage = page + 1
+		}
+		return pagination
+	}
+
 	totalPages := (total + limit - 1) / limit
+	pagination.NextPage = min(totalPages, page+1)
+	pagination.Count = &total
+	pagination.TotalPage = &totalPages
+	return pagination
+}
 
-	return &beersleo.BeerleoPagingResult{
-		Page:      page,
-		Limit:     limit,
-		PrevPage:  max(1, page-1),
-		NextPage:  min(totalPages, page+1),
-		Count:     total,
-		TotalPage: totalPages,
-	}, nil
+// setPageHeaders sets X-Total-Count and an RFC 8288 Link header with first, prev, next and,
+// when the total is known, last pages.
+func setPageHeaders(c *gin.Context, pagination *beersleo.BeerleoPagingResult, total int, counted bool) {
+	links := []string{pageLink(c, "first", 1)}
+	if pagination.Page > 1 {
+		links = append(links, pageLink(c, "prev", pagination.PrevPage))
+	}
+	if pagination.NextPage > pagination.Page {
+		links = append(links, pageLink(c, "next", pagination.NextPage))
+	}
+	if counted {
+		c.Header("X-Total-Count", strconv.Itoa(total))
+		if *pagination.TotalPage > 0 {
+			links = append(links, pageLink(c, "last", *pagination.TotalPage))
+		}
+	}
+	c.Header("Link", strings.Join(links, ", "))
+}
+
+// setCursorHeaders sets an RFC 8288 Link header pointing at the next and prev cursor pages.
+func setCursorHeaders(c *gin.Context, paging *beersleo.BeerleoCursorResult) {
+	var links []string
+	if paging.NextCursor != "" {
+		links = append(links, link(c, "next", "after", paging.NextCursor, "before"))
+	}
+	if paging.PrevCursor != "" {
+		links = append(links, link(c, "prev", "before", paging.PrevCursor, "after"))
+	}
+	if len(links) > 0 {
+		c.Header("Link", strings.Join(links, ", "))
+	}
+}
+
+func pageLink(c *gin.Context, rel string, page int) string {
+	return link(c, rel, "page", strconv.Itoa(page))
+}
+
+// link formats one Link header entry for the current request URL with key set to value and
+// the drop parameters removed.
+func link(c *gin.Context, rel, key, value string, drop ...string) string {
+	query := c.Request.URL.Query()
+	query.Set(key, value)
+	for _, name := range drop {
+		query.Del(name)
+	}
+	u := url.URL{Path: c.Request.URL.Path, RawQuery: query.Encode()}
+	return fmt.Sprintf("<%s%s>; rel=\"%s\"", getHost(c), u.String(), rel)
 }
 
 func max(a, b int) int {
@@ -126,8 +728,15 @@ func min(a, b int) int {
 func (h *beersleoHandler) CreateBeer(c *gin.Context) {
 	var beerCreate beersleo.BeerCreationRequest
 
+	h.limitBody(c)
 	if err := c.ShouldBind(&beerCreate); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to bind request"})
+		_ = c.Error(apperrors.Binding(err))
+		return
+	}
+
+	image, err := h.readBeerImage(c)
+	if err != nil && !errors.Is(err, http.ErrMissingFile) {
+		_ = c.Error(err)
 		return
 	}
 
@@ -137,114 +746,287 @@ func (h *beersleoHandler) CreateBeer(c *gin.Context) {
 		Detail:   beerCreate.Detail,
 	}
 
-	id, err := h.beersleoUsecase.CreateBeer(&beerData)
+	beer, err := h.beersleoUsecase.CreateBeer(c.Request.Context(), &beerData, image)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create beer"})
+		_ = c.Error(err)
 		return
 	}
 
-	beerResponse := beersleo.Beersleo{
-		ID:       id,
-		Name:     beerCreate.Name,
-		Category: beerCreate.Category,
-		Detail:   beerCreate.Detail,
+	c.JSON(http.StatusOK, gin.H{"message": "Beer created successfully", "id": beer.ID})
+}
+
+// UpdateBeer is a full replacement of the beer's fields; name, category and detail are required.
+// The image is replaced when one is uploaded, removed with remove_image=true, and kept otherwise.
+func (h *beersleoHandler) UpdateBeer(c *gin.Context) {
+	var beerUpdate beersleo.UpdateBeer
+
+	h.limitBody(c)
+	if err := c.ShouldBind(&beerUpdate); err != nil {
+		_ = c.Error(apperrors.Binding(err))
+		return
 	}
 
-	imagePath, err := setBeerImage(c, &beerResponse)
+	id, err := getBeerID(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set beer image"})
+		_ = c.Error(err)
 		return
 	}
 
-	beerResponse.Image = imagePath
+	image, err := h.readBeerImage(c)
+	if err != nil && !errors.Is(err, http.ErrMissingFile) {
+		_ = c.Error(err)
+		return
+	}
 
-	err = h.beersleoUsecase.UpdateBeer(&beerResponse)
+	version, err := getIfMatchVersion(c, id)
 	if err != nil {
-		fmt.Println(err)
+		_ = c.Error(err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Beer created successfully"})
-}
-
-func (h *beersleoHandler) UpdateBeer(c *gin.Context) {
-	var beerUpdate beersleo.UpdateBeer
+	patch := &beersleo.BeerPatch{
+		Name:        &beerUpdate.Name,
+		Category:    &beerUpdate.Category,
+		Detail:      &beerUpdate.Detail,
+		RemoveImage: beerUpdate.RemoveImage,
+		Version:     version,
+	}
 
-	if err := c.ShouldBind(&beerUpdate); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to bind request"})
+	beer, err := h.beersleoUsecase.PatchBeer(c.Request.Context(), id, patch, image)
+	if err != nil {
+		_ = c.Error(err)
 		return
 	}
 
-	idStr := c.Param("id")
-	id, err := strconv.Atoi(idStr)
+	c.Header("ETag", beerETag(beer))
+	c.JSON(http.StatusOK, gin.H{"message": "Beer updated successfully"})
+}
+
+// PatchBeer changes only the supplied fields. It accepts multipart/form data (with an optional
+// image and remove_image flag) or an application/merge-patch+json document, where "image": null
+// removes the image.
+func (h *beersleoHandler) PatchBeer(c *gin.Context) {
+	id, err := getBeerID(c)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid beer ID"})
+		_ = c.Error(err)
 		return
 	}
 
-	beerResponse, err := h.beersleoUsecase.GetBeerByID(id)
+	version, err := getIfMatchVersion(c, id)
 	if err != nil {
-		fmt.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch beer details"})
+		_ = c.Error(err)
 		return
 	}
 
-	beerResponse.Name = beerUpdate.Name
-	beerResponse.Category = beerUpdate.Category
-	beerResponse.Detail = beerUpdate.Detail
+	h.limitBody(c)
 
-	imagePath, err := setBeerImage(c, beerResponse)
-	fmt.Println(imagePath)
+	var (
+		patch *beersleo.BeerPatch
+		image *uploads.Image
+	)
+	switch c.ContentType() {
+	case "application/json", "application/merge-patch+json":
+		patch, err = parseMergePatch(c)
+	default:
+		patch, err = parseFormPatch(c)
+		if err == nil {
+			image, err = h.readBeerImage(c)
+			if errors.Is(err, http.ErrMissingFile) {
+				err = nil
+			}
+		}
+	}
 	if err != nil {
-		fmt.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set beer image"})
+		_ = c.Error(err)
 		return
 	}
-	beerResponse.Image = imagePath
+	patch.Version = version
 
-	err = h.beersleoUsecase.UpdateBeer(beerResponse)
+	beer, err := h.beersleoUsecase.PatchBeer(c.Request.Context(), id, patch, image)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update beer"})
+		_ = c.Error(err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Beer updated successfully"})
+	c.Header("ETag", beerETag(beer))
+	h.setImageURLs(c, beer)
+	c.JSON(http.StatusOK, beer)
 }
 
-func setBeerImage(c *gin.Context, beer *beersleo.Beersleo) (string, error) {
-	file, err := c.FormFile("image")
-	if err != nil {
-		// ถ้ามีข้อผิดพลาด ส่งคืนค่าข้อผิดพลาด
-		return "", err
+func parseFormPatch(c *gin.Context) (*beersleo.BeerPatch, error) {
+	patch := &beersleo.BeerPatch{}
+	fields := map[string]**string{
+		"name":     &patch.Name,
+		"category": &patch.Category,
+		"detail":   &patch.Detail,
+	}
+	for name, target := range fields {
+		if value, ok := c.GetPostForm(name); ok {
+			value := value
+			*target = &value
+		}
 	}
 
-	if beer.Image != "" {
-		beer.Image = strings.Replace(beer.Image, getHost(c), "", 1)
-		pwd, err := os.Getwd()
+	if value, ok := c.GetPostForm("remove_image"); ok {
+		removeImage, err := strconv.ParseBool(value)
 		if err != nil {
-			// ถ้ามีข้อผิดพลาด ส่งคืนค่าข้อผิดพลาด
-			return "", err
+			return nil, apperrors.Validation("Invalid remove_image value")
 		}
+		patch.RemoveImage = removeImage
+	}
 
-		if err := os.Remove(pwd + beer.Image); err != nil {
-			return "", err
+	return patch, validatePatch(patch)
+}
+
+// parseMergePatch reads an RFC 7396 merge patch. Images cannot be uploaded as JSON, so the
+// only accepted value for "image" is null.
+func parseMergePatch(c *gin.Context) (*beersleo.BeerPatch, error) {
+	var doc map[string]json.RawMessage
+	if err := json.NewDecoder(c.Request.Body).Decode(&doc); err != nil {
+		return nil, apperrors.Binding(err)
+	}
+
+	patch := &beersleo.BeerPatch{}
+	fields := map[string]**string{
+		"name":     &patch.Name,
+		"category": &patch.Category,
+		"detail":   &patch.Detail,
+	}
+	invalid := make(map[string]string)
+	for name, target := range fields {
+		raw, ok := doc[name]
+		if !ok {
+			continue
+		}
+		var value *string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			invalid[name] = "string"
+			continue
 		}
+		if value == nil {
+			invalid[name] = "required"
+			continue
+		}
+		*target = value
 	}
-	path := "uploads/beers/" + strconv.Itoa(int(beer.ID))
 
-	if err := os.MkdirAll(path, 0755); err != nil {
-		return "", err
+	if raw, ok := doc["image"]; ok {
+		if string(raw) != "null" {
+			invalid["image"] = "null"
+		} else {
+			patch.RemoveImage = true
+		}
+	}
+
+	if len(invalid) > 0 {
+		return nil, apperrors.Validation("Request validation failed").With("fields", invalid)
 	}
+	return patch, validatePatch(patch)
+}
 
-	fileName := path + "/" + file.Filename
+// validatePatch rejects blanking a required field.
+func validatePatch(patch *beersleo.BeerPatch) error {
+	invalid := make(map[string]string)
+	if patch.Name != nil && strings.TrimSpace(*patch.Name) == "" {
+		invalid["name"] = "required"
+	}
+	if patch.Category != nil && strings.TrimSpace(*patch.Category) == "" {
+		invalid["category"] = "required"
+	}
+	if patch.Detail != nil && strings.TrimSpace(*patch.Detail) == "" {
+		invalid["detail"] = "required"
+	}
+	if len(invalid) > 0 {
+		return apperrors.Validation("Request validation failed").With("fields", invalid)
+	}
+	return nil
+}
 
-	if err := c.SaveUploadedFile(file, fileName); err != nil {
-		return "", err
+// multipartOverhead leaves room for the text fields and multipart framing around the image.
+const multipartOverhead = 1 << 20
+
+// limitBody caps the request body so oversized uploads are rejected while reading, not after.
+func (h *beersleoHandler) limitBody(c *gin.Context) {
+	if maxSize := h.cfg.ImageMaxSize(); maxSize > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize+multipartOverhead)
+	}
+}
+
+// readBeerImage validates the "image" form file. It returns http.ErrMissingFile when none was sent.
+func (h *beersleoHandler) readBeerImage(c *gin.Context) (*uploads.Image, error) {
+	file, err := c.FormFile("image")
+	if errors.Is(err, http.ErrMissingFile) {
+		return nil, err
+	}
+	if err != nil {
+		// ถ้ามีข้อผิดพลาด ส่งคืนค่าข้อผิดพลาด
+		metrics.UploadFailed(metrics.UploadInvalid)
+		return nil, apperrors.Wrap(err, apperrors.KindValidation, "Failed to read image")
+	}
+
+	image, err := uploads.ValidateImage(file, h.cfg.ImageMaxSize(), h.cfg.ImageMaxPixels())
+	switch {
+	case err == nil:
+		return image, nil
+	case errors.Is(err, uploads.ErrTooLarge):
+		metrics.UploadFailed(metrics.UploadTooLarge)
+		return nil, apperrors.Wrap(err, apperrors.KindTooLarge, "Image exceeds the maximum upload size").
+			With("maxBytes", h.cfg.ImageMaxSize())
+	case errors.Is(err, uploads.ErrTooManyPixels):
+		metrics.UploadFailed(metrics.UploadTooLarge)
+		return nil, apperrors.Wrap(err, apperrors.KindTooLarge, "Image exceeds the maximum dimensions").
+			With("maxPixels", h.cfg.ImageMaxPixels())
+	case errors.Is(err, uploads.ErrUnsupportedType), errors.Is(err, uploads.ErrExtensionMismatch):
+		metrics.UploadFailed(metrics.UploadUnsupportedType)
+		return nil, apperrors.Wrap(err, apperrors.KindUnsupportedMedia, "%s", err.Error()).
+			With("allowed", uploads.AllowedTypes())
+	default:
+		metrics.UploadFailed(metrics.UploadInvalid)
+		return nil, apperrors.Wrap(err, apperrors.KindValidation, "Failed to read image")
+	}
+}
+
+// imageURL turns an image key, of the given variant size or 0 for the original, into the URL
+// handed to clients: the store's own URL, or endpoint, the API path that serves the image, for
+// stores that are served through the API.
+func (h *beersleoHandler) imageURL(c *gin.Context, endpoint, key string, size int) string {
+	if url := h.imageStore.URL(key); url != "" {
+		return url
 	}
+	url := getHost(c) + endpoint
+	if size > 0 {
+		url += "?size=" + strconv.Itoa(size)
+	}
+	return url
+}
 
-	beer.Image = getHost(c) + "/" + fileName
+// setImageURLs replaces the stored image key with its URL and fills in the per-size variants.
+// Images without variants (legacy rows, WebP) point every size at the original.
+func (h *beersleoHandler) setImageURLs(c *gin.Context, beer *beersleo.Beersleo) {
+	if beer.Image == "" {
+		return
+	}
+	beer.Image, beer.Images = h.imageURLs(c, beerImageEndpoint(beer.ID), beer.Image)
+}
 
-	return beer.Image, nil
+// imageURLs returns the URL of the stored image and its per-size URLs, served at endpoint.
+func (h *beersleoHandler) imageURLs(c *gin.Context, endpoint, image string) (string, map[string]string) {
+	key := beersleo.ImageKey(image)
+	original := h.imageURL(c, endpoint, key, 0)
+	images := map[string]string{"original": original}
+	for _, size := range uploads.VariantSizes {
+		variantURL := original
+		if uploads.HasVariants(key) {
+			variantKey, _ := uploads.VariantKey(key, size)
+			variantURL = h.imageURL(c, endpoint, variantKey, size)
+		}
+		images[strconv.Itoa(size)] = variantURL
+	}
+	return original, images
+}
+
+func beerImageEndpoint(id int) string {
+	return "/v1/beers/" + strconv.Itoa(id) + "/image"
 }
 
 func getHost(c *gin.Context) string {
@@ -256,40 +1038,39 @@ func getHost(c *gin.Context) string {
 }
 
 func (h *beersleoHandler) DeleteBeer(c *gin.Context) {
-	idStr := c.Param("id")
-	id, err := strconv.Atoi(idStr)
+	id, err := getBeerID(c)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid beer ID"})
+		_ = c.Error(err)
 		return
 	}
 
-	err = h.beersleoUsecase.DeleteBeer(id)
+	version, err := getIfMatchVersion(c, id)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete beer"})
+		_ = c.Error(err)
+		return
+	}
+
+	err = h.beersleoUsecase.DeleteBeer(c.Request.Context(), id, version)
+	if err != nil {
+		_ = c.Error(err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Beer deleted successfully"})
 }
 
-func (h *beersleoHandler) FilterBeersByName(c *gin.Context) {
-
-	nameQuery := c.DefaultQuery("name", "")
-
-	if nameQuery == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Name query parameter is required"})
+func (h *beersleoHandler) RestoreBeer(c *gin.Context) {
+	id, err := getBeerID(c)
+	if err != nil {
+		_ = c.Error(err)
 		return
 	}
 
-	filter := &beersleo.BeersleoFilter{
-		Name: nameQuery,
-	}
-
-	beersData, err := h.beersleoUsecase.FilterBeersByName(filter)
-
+	err = h.beersleoUsecase.RestoreBeer(c.Request.Context(), id)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to filter beers by name"})
+		_ = c.Error(err)
 		return
 	}
-	c.JSON(http.StatusOK, beersData)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Beer restored successfully"})
 }
@@ -0,0 +1,128 @@
+package beersleoHandlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/peedans/beerleo/pkg/apperrors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func testContext(target string, header http.Header) (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, target, nil)
+	for key, values := range header {
+		c.Request.Header[key] = values
+	}
+	return c, w
+}
+
+func TestGetIfMatchVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		ifMatch string
+		want    int
+		wantErr bool
+	}{
+		{name: "absent", ifMatch: "", want: 0},
+		{name: "any", ifMatch: "*", want: 0},
+		{name: "current", ifMatch: `"7-3"`, want: 3},
+		{name: "one of a list", ifMatch: `"8-1", "7-4"`, want: 4},
+		{name: "other beer", ifMatch: `"8-3"`, wantErr: true},
+		{name: "weak", ifMatch: `W/"7-3"`, wantErr: true},
+		{name: "unquoted", ifMatch: `7-3`, wantErr: true},
+		{name: "zero version", ifMatch: `"7-0"`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := testContext("/v1/beers/7", http.Header{"If-Match": {tt.ifMatch}})
+			got, err := getIfMatchVersion(c, 7)
+			if tt.wantErr {
+				if !apperrors.Is(err, apperrors.KindPrecondition) {
+					t.Fatalf("err = %v, want a failed precondition", err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("getIfMatchVersion = %d, %v, want %d", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetPageHeaders(t *testing.T) {
+	tests := []struct {
+		name      string
+		target    string
+		page      int
+		limit     int
+		total     int
+		counted   bool
+		wantLink  string
+		wantTotal string
+	}{
+		{
+			name:      "first of three",
+			target:    "/v1/beers?page=1&limit=10&name=ipa",
+			page:      1,
+			limit:     10,
+			total:     25,
+			counted:   true,
+			wantLink:  `<http://example.com/v1/beers?limit=10&name=ipa&page=1>; rel="first", <http://example.com/v1/beers?limit=10&name=ipa&page=2>; rel="next", <http://example.com/v1/beers?limit=10&name=ipa&page=3>; rel="last"`,
+			wantTotal: "25",
+		},
+		{
+			name:      "last page",
+			target:    "/v1/beers?page=3&limit=10",
+			page:      3,
+			limit:     10,
+			total:     25,
+			counted:   true,
+			wantLink:  `<http://example.com/v1/beers?limit=10&page=1>; rel="first", <http://example.com/v1/beers?limit=10&page=2>; rel="prev", <http://example.com/v1/beers?limit=10&page=3>; rel="last"`,
+			wantTotal: "25",
+		},
+		{
+			name:      "empty",
+			target:    "/v1/beers",
+			page:      1,
+			limit:     10,
+			total:     0,
+			counted:   true,
+			wantLink:  `<http://example.com/v1/beers?page=1>; rel="first"`,
+			wantTotal: "0",
+		},
+		{
+			name:     "uncounted with more",
+			target:   "/v1/beers?page=2&limit=10&count=false",
+			page:     2,
+			limit:    10,
+			total:    21,
+			wantLink: `<http://example.com/v1/beers?count=false&limit=10&page=1>; rel="first", <http://example.com/v1/beers?count=false&limit=10&page=1>; rel="prev", <http://example.com/v1/beers?count=false&limit=10&page=3>; rel="next"`,
+		},
+		{
+			name:     "uncounted at the end",
+			target:   "/v1/beers?page=2&limit=10&count=false",
+			page:     2,
+			limit:    10,
+			total:    20,
+			wantLink: `<http://example.com/v1/beers?count=false&limit=10&page=1>; rel="first", <http://example.com/v1/beers?count=false&limit=10&page=1>; rel="prev"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, w := testContext(tt.target, nil)
+			setPageHeaders(c, getPagination(tt.page, tt.limit, tt.total, tt.counted), tt.total, tt.counted)
+
+			if got := w.Header().Get("Link"); got != tt.wantLink {
+				t.Errorf("Link = %s\nwant   %s", got, tt.wantLink)
+			}
+			if got := w.Header().Get("X-Total-Count"); got != tt.wantTotal {
+				t.Errorf("X-Total-Count = %q, want %q", got, tt.wantTotal)
+			}
+		})
+	}
+}
@@ -0,0 +1,467 @@
+package beersleoHandlers
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/peedans/beerleo/modules/beersleo"
+	"github.com/peedans/beerleo/pkg/apperrors"
+	"github.com/peedans/beerleo/pkg/uploads"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Import file formats, picked by file extension or, failing that, by content type.
+const (
+	formatCSV    = "csv"
+	formatJSON   = "json"
+	formatNDJSON = "ndjson"
+	formatZip    = "zip"
+)
+
+var importFormats = map[string]string{
+	".csv":                 formatCSV,
+	".json":                formatJSON,
+	".ndjson":              formatNDJSON,
+	".jsonl":               formatNDJSON,
+	".zip":                 formatZip,
+	"text/csv":             formatCSV,
+	"application/json":     formatJSON,
+	"application/x-ndjson": formatNDJSON,
+	"application/jsonl":    formatNDJSON,
+	"application/zip":      formatZip,
+}
+
+// importRecord is a row as it appears in a JSON or NDJSON import.
+type importRecord struct {
+	Name     string `json:"name"`
+	Category string `json:"category"`
+	Detail   string `json:"detail"`
+	Image    string `json:"image"`
+}
+
+// ImportBeers loads many beers at once. The file is sent as the "file" form field or as the raw
+// body and is CSV (with a name,category,detail[,image] header), a JSON array or NDJSON. A zip
+// archive may bundle one such file with the images its image column refers to by file name.
+// dry_run=true only validates, and mode=upsert updates beers whose name already exists.
+func (h *beersleoHandler) ImportBeers(c *gin.Context) {
+	opts := &beersleo.BeerImportOptions{ChunkSize: h.cfg.ImportChunkSize()}
+
+	if value := c.Query("dry_run"); value != "" {
+		dryRun, err := strconv.ParseBool(value)
+		if err != nil {
+			_ = c.Error(apperrors.Validation("Invalid dry_run value %q", value))
+			return
+		}
+		opts.DryRun = dryRun
+	}
+	switch mode := c.DefaultQuery("mode", "create"); mode {
+	case "create":
+	case "upsert":
+		opts.Upsert = true
+	default:
+		_ = c.Error(apperrors.Validation("Invalid mode %q, must be create or upsert", mode))
+		return
+	}
+
+	if maxSize := h.cfg.ImportMaxSize(); maxSize > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize+multipartOverhead)
+	}
+	name, data, err := readImportFile(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	rows, err := h.parseImport(name, c.ContentType(), data)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if len(rows) == 0 {
+		_ = c.Error(apperrors.Validation("Import file contains no rows"))
+		return
+	}
+	if maxRows := h.cfg.ImportMaxRows(); maxRows > 0 && len(rows) > maxRows {
+		_ = c.Error(apperrors.New(apperrors.KindTooLarge, "Import file has %d rows, at most %d are allowed", len(rows), maxRows).
+			With("maxRows", maxRows))
+		return
+	}
+
+	report, err := h.beersleoUsecase.ImportBeers(c.Request.Context(), rows, opts)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// readImportFile returns the uploaded "file" form field, or the raw body when the request is
+// not multipart, together with the file name (empty for a raw body).
+func readImportFile(c *gin.Context) (string, []byte, error) {
+	if c.ContentType() != gin.MIMEMultipartPOSTForm {
+		data, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			return "", nil, apperrors.Binding(err)
+		}
+		return "", data, nil
+	}
+
+	file, err := c.FormFile("file")
+	if errors.Is(err, http.ErrMissingFile) {
+		return "", nil, apperrors.Validation("Import file is required").With("fields", map[string]string{"file": "required"})
+	}
+	if err != nil {
+		return "", nil, apperrors.Binding(err)
+	}
+	src, err := file.Open()
+	if err != nil {
+		return "", nil, apperrors.Wrap(err, apperrors.KindValidation, "Failed to read import file")
+	}
+	defer src.Close()
+
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return "", nil, apperrors.Wrap(err, apperrors.KindValidation, "Failed to read import file")
+	}
+	return file.Filename, data, nil
+}
+
+// parseImport turns the import file into rows. Problems with a single row are recorded on
+// that row; only an unreadable file as a whole is an error.
+func (h *beersleoHandler) parseImport(name, contentType string, data []byte) ([]*beersleo.BeerImportRow, error) {
+	format := importFormat(name, contentType)
+	if format == "" {
+		return nil, apperrors.New(apperrors.KindUnsupportedMedia, "Unsupported import file, expected CSV, JSON, NDJSON or zip").
+			With("allowed", []string{"text/csv", "application/json", "application/x-ndjson", "application/zip"})
+	}
+
+	var images map[string]*zip.File
+	if format == formatZip {
+		var err error
+		name, data, images, err = h.readImportZip(data)
+		if err != nil {
+			return nil, err
+		}
+		format = importFormat(name, "")
+	}
+
+	var (
+		rows   []*beersleo.BeerImportRow
+		err    error
+		linked = make(map[*beersleo.BeerImportRow]string)
+	)
+	switch format {
+	case formatCSV:
+		rows, err = parseImportCSV(data, linked)
+	case formatJSON:
+		rows, err = parseImportJSON(data, linked)
+	case formatNDJSON:
+		rows, err = parseImportNDJSON(data, linked)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Rows often share an image, so each entry is read and validated once.
+	read := &importImages{entries: make(map[*zip.File]*importImage)}
+	for _, row := range rows {
+		validateImportRow(row)
+		if imageName := linked[row]; imageName != "" {
+			h.attachImportImage(row, path.Dir(name), imageName, images, read)
+		}
+	}
+	// Every image stays in memory until the import is done, and a small archive can hold a lot
+	// of them, so their total is limited as well as each one.
+	if maxSize := h.cfg.ImportMaxImagesSize(); maxSize > 0 && read.size > maxSize {
+		return nil, apperrors.New(apperrors.KindTooLarge, "Images in zip archive exceed %d bytes in total, split the import", maxSize).
+			With("maxImagesBytes", maxSize)
+	}
+	return rows, nil
+}
+
+func importFormat(name, contentType string) string {
+	if format, ok := importFormats[strings.ToLower(path.Ext(name))]; ok {
+		return format
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return importFormats[mediaType]
+}
+
+// readImportZip finds the single data file in the archive and indexes the other entries by
+// path, and by base name where that is unambiguous.
+func (h *beersleoHandler) readImportZip(data []byte) (string, []byte, map[string]*zip.File, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", nil, nil, apperrors.Wrap(err, apperrors.KindValidation, "Invalid zip archive")
+	}
+
+	var (
+		dataFile *zip.File
+		files    []*zip.File
+	)
+	for _, file := range archive.File {
+		if file.FileInfo().IsDir() || strings.HasPrefix(file.Name, "__MACOSX/") {
+			continue
+		}
+		if format := importFormat(file.Name, ""); format != "" && format != formatZip {
+			if dataFile != nil {
+				return "", nil, nil, apperrors.Validation("Zip archive contains more than one data file: %s and %s", dataFile.Name, file.Name)
+			}
+			dataFile = file
+			continue
+		}
+		files = append(files, file)
+	}
+	if dataFile == nil {
+		return "", nil, nil, apperrors.Validation("Zip archive contains no CSV, JSON or NDJSON file")
+	}
+
+	images := make(map[string]*zip.File, len(files))
+	baseNames := make(map[string]int, len(files))
+	for _, file := range files {
+		images[path.Clean(file.Name)] = file
+		baseNames[path.Base(file.Name)]++
+	}
+	for _, file := range files {
+		base := path.Base(file.Name)
+		if _, ok := images[base]; !ok && baseNames[base] == 1 {
+			images[base] = file
+		}
+	}
+
+	tooLarge := apperrors.New(apperrors.KindTooLarge, "Data file in zip archive exceeds the maximum import size")
+	maxSize := h.cfg.ImportMaxSize()
+	if maxSize > 0 && dataFile.UncompressedSize64 > uint64(maxSize) {
+		return "", nil, nil, tooLarge
+	}
+	src, err := dataFile.Open()
+	if err != nil {
+		return "", nil, nil, apperrors.Wrap(err, apperrors.KindValidation, "Failed to read %s from zip archive", dataFile.Name)
+	}
+	defer src.Close()
+
+	// The declared size can lie, so the read is capped as well.
+	reader := io.Reader(src)
+	if maxSize > 0 {
+		reader = io.LimitReader(src, maxSize+1)
+	}
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return "", nil, nil, apperrors.Wrap(err, apperrors.KindValidation, "Failed to read %s from zip archive", dataFile.Name)
+	}
+	if maxSize > 0 && int64(len(content)) > maxSize {
+		return "", nil, nil, tooLarge
+	}
+	return dataFile.Name, content, images, nil
+}
+
+// parseImportCSV reads a CSV file whose header names the columns, in any order.
+func parseImportCSV(data []byte, linked map[*beersleo.BeerImportRow]string) ([]*beersleo.BeerImportRow, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, apperrors.Wrap(err, apperrors.KindValidation, "Failed to read CSV header")
+	}
+	columns := make(map[string]int, len(header))
+	for i, column := range header {
+		columns[strings.ToLower(strings.TrimSpace(column))] = i
+	}
+	var missing []string
+	for _, column := range []string{"name", "category", "detail"} {
+		if _, ok := columns[column]; !ok {
+			missing = append(missing, column)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, apperrors.Validation("CSV header is missing columns: %s", strings.Join(missing, ", "))
+	}
+
+	field := func(record []string, column string) string {
+		if i, ok := columns[column]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var rows []*beersleo.BeerImportRow
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+
+		row := &beersleo.BeerImportRow{Row: len(rows) + 1}
+
+		var parseErr *csv.ParseError
+		switch {
+		case errors.As(err, &parseErr):
+			row.Line = parseErr.StartLine
+			row.Errors = map[string]string{"row": parseErr.Err.Error()}
+		case err != nil:
+			return nil, apperrors.Wrap(err, apperrors.KindValidation, "Failed to read CSV")
+		case len(record) != len(header):
+			row.Line, _ = reader.FieldPos(0)
+			row.Errors = map[string]string{"row": "has " + strconv.Itoa(len(record)) + " fields, the header has " + strconv.Itoa(len(header))}
+		default:
+			row.Line, _ = reader.FieldPos(0)
+			row.Name = field(record, "name")
+			row.Category = field(record, "category")
+			row.Detail = field(record, "detail")
+			linked[row] = field(record, "image")
+		}
+		rows = append(rows, row)
+	}
+}
+
+func parseImportJSON(data []byte, linked map[*beersleo.BeerImportRow]string) ([]*beersleo.BeerImportRow, error) {
+	var records []json.RawMessage
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, apperrors.Wrap(err, apperrors.KindValidation, "Import JSON must be an array of objects")
+	}
+
+	rows := make([]*beersleo.BeerImportRow, len(records))
+	for i, raw := range records {
+		rows[i] = decodeImportRecord(i+1, 0, raw, linked)
+	}
+	return rows, nil
+}
+
+func parseImportNDJSON(data []byte, linked map[*beersleo.BeerImportRow]string) ([]*beersleo.BeerImportRow, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+
+	var rows []*beersleo.BeerImportRow
+	for line := 1; scanner.Scan(); line++ {
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+		rows = append(rows, decodeImportRecord(len(rows)+1, line, raw, linked))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, apperrors.Wrap(err, apperrors.KindValidation, "Failed to read NDJSON")
+	}
+	return rows, nil
+}
+
+func decodeImportRecord(index, line int, raw []byte, linked map[*beersleo.BeerImportRow]string) *beersleo.BeerImportRow {
+	row := &beersleo.BeerImportRow{Row: index, Line: line}
+
+	var record importRecord
+	if err := json.Unmarshal(raw, &record); err != nil {
+		row.Errors = map[string]string{"row": "invalid JSON object: " + err.Error()}
+		return row
+	}
+	row.Name = strings.TrimSpace(record.Name)
+	row.Category = strings.TrimSpace(record.Category)
+	row.Detail = strings.TrimSpace(record.Detail)
+	linked[row] = strings.TrimSpace(record.Image)
+	return row
+}
+
+// validateImportRow applies the CreateBeer binding rules to the row.
+func validateImportRow(row *beersleo.BeerImportRow) {
+	if len(row.Errors) > 0 {
+		return
+	}
+	err := binding.Validator.ValidateStruct(&beersleo.BeerCreationRequest{
+		Name:     row.Name,
+		Category: row.Category,
+		Detail:   row.Detail,
+	})
+
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		row.Errors = make(map[string]string, len(validationErrs))
+		for _, fieldErr := range validationErrs {
+			row.Errors[strings.ToLower(fieldErr.Field())] = fieldErr.Tag()
+		}
+	}
+}
+
+// importImage is the outcome of reading one zip entry as an image.
+type importImage struct {
+	image *uploads.Image
+	err   error
+}
+
+// importImages are the zip entries read so far and their total size.
+type importImages struct {
+	entries map[*zip.File]*importImage
+	size    int64
+}
+
+// attachImportImage validates the image a row refers to with the same rules as an upload.
+// name is looked up relative to dir, the data file's folder, then from the archive root.
+func (h *beersleoHandler) attachImportImage(row *beersleo.BeerImportRow, dir, name string, images map[string]*zip.File, read *importImages) {
+	setError := func(message string) {
+		if row.Errors == nil {
+			row.Errors = make(map[string]string)
+		}
+		row.Errors["image"] = message
+	}
+
+	if images == nil {
+		setError("images can only be imported from a zip archive")
+		return
+	}
+	file, ok := images[path.Join(dir, name)]
+	if !ok {
+		file, ok = images[path.Clean(strings.TrimPrefix(name, "/"))]
+	}
+	if !ok {
+		setError("file " + strconv.Quote(name) + " is not in the zip archive")
+		return
+	}
+
+	result, ok := read.entries[file]
+	if !ok {
+		result = &importImage{}
+		// Once over the total the rest is not read; the import fails as a whole anyway.
+		if maxSize := h.cfg.ImportMaxImagesSize(); maxSize > 0 && read.size > maxSize {
+			result.err = uploads.ErrTooLarge
+		} else {
+			result.image, result.err = h.readZipImage(file)
+		}
+		if result.image != nil {
+			read.size += int64(len(result.image.Data))
+		}
+		read.entries[file] = result
+	}
+	if result.err != nil {
+		setError(result.err.Error())
+		return
+	}
+	if len(row.Errors) == 0 {
+		row.Image = result.image
+	}
+}
+
+func (h *beersleoHandler) readZipImage(file *zip.File) (*uploads.Image, error) {
+	if maxSize := h.cfg.ImageMaxSize(); maxSize > 0 && file.UncompressedSize64 > uint64(maxSize) {
+		return nil, uploads.ErrTooLarge
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	return uploads.ReadImage(file.Name, src, h.cfg.ImageMaxSize(), h.cfg.ImageMaxPixels())
+}
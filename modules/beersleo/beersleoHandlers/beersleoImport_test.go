@@ -0,0 +1,143 @@
+package beersleoHandlers
+
+import (
+	"github.com/peedans/beerleo/modules/beersleo"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// importedRow is the part of a parsed row the parsers are responsible for.
+type importedRow struct {
+	Row, Line                     int
+	Name, Category, Detail, Image string
+	Errors                        []string
+}
+
+func summarizeRows(rows []*beersleo.BeerImportRow, linked map[*beersleo.BeerImportRow]string) []importedRow {
+	summary := make([]importedRow, len(rows))
+	for i, row := range rows {
+		summary[i] = importedRow{Row: row.Row, Line: row.Line, Name: row.Name, Category: row.Category, Detail: row.Detail, Image: linked[row]}
+		for field := range row.Errors {
+			summary[i].Errors = append(summary[i].Errors, field)
+		}
+		sort.Strings(summary[i].Errors)
+	}
+	return summary
+}
+
+func TestParseImportCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []importedRow
+		wantErr bool
+	}{
+		{
+			name: "columns in any order",
+			data: "Detail,name,CATEGORY,image\nCrisp,Leo,Lager,leo.png\nDark,Guinness,Stout,\n",
+			want: []importedRow{
+				{Row: 1, Line: 2, Name: "Leo", Category: "Lager", Detail: "Crisp", Image: "leo.png"},
+				{Row: 2, Line: 3, Name: "Guinness", Category: "Stout", Detail: "Dark"},
+			},
+		},
+		{
+			name: "byte order mark and spaces",
+			data: "\xef\xbb\xbfname, category, detail\n  Leo ,  Lager , Crisp \n",
+			want: []importedRow{{Row: 1, Line: 2, Name: "Leo", Category: "Lager", Detail: "Crisp"}},
+		},
+		{
+			name: "quoted field over two lines",
+			data: "name,category,detail\nLeo,Lager,\"Crisp\nand light\"\nSingha,Lager,Malty\n",
+			want: []importedRow{
+				{Row: 1, Line: 2, Name: "Leo", Category: "Lager", Detail: "Crisp\nand light"},
+				{Row: 2, Line: 4, Name: "Singha", Category: "Lager", Detail: "Malty"},
+			},
+		},
+		{
+			name: "wrong field count is reported and parsing goes on",
+			data: "name,category,detail\nLeo,Lager\nSingha,Lager,Malty\n",
+			want: []importedRow{
+				{Row: 1, Line: 2, Errors: []string{"row"}},
+				{Row: 2, Line: 3, Name: "Singha", Category: "Lager", Detail: "Malty"},
+			},
+		},
+		{
+			name: "bad quoting is reported",
+			data: "name,category,detail\nLeo,\"Lager,Crisp\n",
+			want: []importedRow{{Row: 1, Line: 2, Errors: []string{"row"}}},
+		},
+		{name: "header only", data: "name,category,detail\n", want: []importedRow{}},
+		{name: "missing column", data: "name,detail\nLeo,Crisp\n", wantErr: true},
+		{name: "empty", data: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			linked := make(map[*beersleo.BeerImportRow]string)
+			rows, err := parseImportCSV([]byte(tt.data), linked)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseImportCSV succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseImportCSV: %v", err)
+			}
+			if got := summarizeRows(rows, linked); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("parseImportCSV =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseImportNDJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []importedRow
+	}{
+		{
+			name: "one object per line",
+			data: `{"name":"Leo","category":"Lager","detail":"Crisp","image":"leo.png"}` + "\n" +
+				`{"name":" Guinness ","category":"Stout","detail":"Dark"}` + "\n",
+			want: []importedRow{
+				{Row: 1, Line: 1, Name: "Leo", Category: "Lager", Detail: "Crisp", Image: "leo.png"},
+				{Row: 2, Line: 2, Name: "Guinness", Category: "Stout", Detail: "Dark"},
+			},
+		},
+		{
+			name: "blank lines are skipped but counted",
+			data: "\n" + `{"name":"Leo","category":"Lager","detail":"Crisp"}` + "\n\n  \n" +
+				`{"name":"Singha","category":"Lager","detail":"Malty"}`,
+			want: []importedRow{
+				{Row: 1, Line: 2, Name: "Leo", Category: "Lager", Detail: "Crisp"},
+				{Row: 2, Line: 5, Name: "Singha", Category: "Lager", Detail: "Malty"},
+			},
+		},
+		{
+			name: "invalid line is reported and parsing goes on",
+			data: `{"name":"Leo"` + "\n" + `["Singha"]` + "\n" + `{"name":"Chang","category":"Lager","detail":"Strong"}`,
+			want: []importedRow{
+				{Row: 1, Line: 1, Errors: []string{"row"}},
+				{Row: 2, Line: 2, Errors: []string{"row"}},
+				{Row: 3, Line: 3, Name: "Chang", Category: "Lager", Detail: "Strong"},
+			},
+		},
+		{name: "empty", data: "", want: []importedRow{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			linked := make(map[*beersleo.BeerImportRow]string)
+			rows, err := parseImportNDJSON([]byte(tt.data), linked)
+			if err != nil {
+				t.Fatalf("parseImportNDJSON: %v", err)
+			}
+			if got := summarizeRows(rows, linked); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("parseImportNDJSON =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
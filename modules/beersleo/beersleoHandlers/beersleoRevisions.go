@@ -0,0 +1,129 @@
+package beersleoHandlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/peedans/beerleo/modules/beersleo"
+	"github.com/peedans/beerleo/pkg/apperrors"
+	"net/http"
+	"strconv"
+)
+
+// ListBeerRevisions lists the saved revisions of a beer, newest first.
+func (h *beersleoHandler) ListBeerRevisions(c *gin.Context) {
+	id, err := getBeerID(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	revisions, err := h.beersleoUsecase.ListBeerRevisions(c.Request.Context(), id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	for _, rev := range revisions {
+		h.setRevisionImageURLs(c, rev)
+	}
+	c.JSON(http.StatusOK, gin.H{"data": revisions})
+}
+
+// GetBeerRevision returns a revision, the current beer and the fields in which they differ.
+func (h *beersleoHandler) GetBeerRevision(c *gin.Context) {
+	id, err := getBeerID(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	revision, err := getRevision(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	result, err := h.beersleoUsecase.GetBeerRevision(c.Request.Context(), id, revision)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	h.setRevisionImageURLs(c, result.Revision)
+	h.setImageURLs(c, result.Current)
+	if _, ok := result.Diff["image"]; ok {
+		result.Diff["image"] = beersleo.FieldDiff{Revision: result.Revision.Image, Current: result.Current.Image}
+	}
+
+	c.Header("ETag", beerETag(result.Current))
+	c.JSON(http.StatusOK, result)
+}
+
+// GetBeerRevisionImage streams the image a revision of a beer refers to, like GetBeerImage.
+func (h *beersleoHandler) GetBeerRevisionImage(c *gin.Context) {
+	id, err := getBeerID(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	revision, err := getRevision(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	result, err := h.beersleoUsecase.GetBeerRevision(c.Request.Context(), id, revision)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if result.Revision.Image == "" {
+		_ = c.Error(apperrors.NotFound("Revision %d of beer %d has no image", revision, id))
+		return
+	}
+
+	h.serveImage(c, result.Revision.Image, apperrors.NotFound("Image of revision %d of beer %d not found", revision, id))
+}
+
+// RevertBeer puts a revision's fields back. If-Match is honoured like on PUT.
+func (h *beersleoHandler) RevertBeer(c *gin.Context) {
+	id, err := getBeerID(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	revision, err := getRevision(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	version, err := getIfMatchVersion(c, id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	beer, err := h.beersleoUsecase.RevertBeer(c.Request.Context(), id, revision, version)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	h.setImageURLs(c, beer)
+	c.Header("ETag", beerETag(beer))
+	c.JSON(http.StatusOK, beer)
+}
+
+func (h *beersleoHandler) setRevisionImageURLs(c *gin.Context, rev *beersleo.BeerRevision) {
+	if rev.Image == "" {
+		return
+	}
+	endpoint := "/v1/beers/" + strconv.Itoa(rev.BeerID) + "/revisions/" + strconv.Itoa(rev.Revision) + "/image"
+	rev.Image, rev.Images = h.imageURLs(c, endpoint, rev.Image)
+}
+
+func getRevision(c *gin.Context) (int, error) {
+	revision, err := strconv.Atoi(c.Param("rev"))
+	if err != nil || revision <= 0 {
+		return 0, apperrors.Validation("Invalid revision")
+	}
+	return revision, nil
+}
@@ -1,41 +1,147 @@
 package beersleoRepositories
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/jmoiron/sqlx"
+	"github.com/peedans/beerleo/modules/audit"
 	"github.com/peedans/beerleo/modules/beersleo"
+	"github.com/peedans/beerleo/modules/categories"
+	"github.com/peedans/beerleo/pkg/apperrors"
+	"github.com/peedans/beerleo/pkg/metrics"
+	"strings"
+	"time"
 )
 
+// repositoryName labels the query duration metrics of this repository.
+const repositoryName = "beers"
+
 type IBeersleoRepository interface {
-	FilterBeersByName(req *beersleo.BeersleoFilter) ([]*beersleo.Beersleo, error)
-	GetByID(id int) (*beersleo.Beersleo, error)
-	Delete(id int) error
-	GetAllBeersWithPagination(page, limit int) ([]*beersleo.Beersleo, int, error)
+	GetByID(id int, includeDeleted bool) (*beersleo.Beersleo, error)
+	GetByIDs(ids []int) ([]*beersleo.Beersleo, error)
+	GetByName(name string) ([]*beersleo.Beersleo, error)
+	Delete(id int, version int) error
+	Restore(id int) error
+	Purge(deletedBefore time.Time) ([]*beersleo.Beersleo, []string, error)
+	GetAllBeersWithPagination(filter *beersleo.BeersleoFilter) ([]*beersleo.Beersleo, int, error)
+	GetAllBeersWithKeyset(filter *beersleo.BeersleoFilter) ([]*beersleo.Beersleo, error)
+	Search(filter *beersleo.BeersleoFilter) ([]*beersleo.BeerSearchResult, int, error)
+	SearchWithKeyset(filter *beersleo.BeersleoFilter) ([]*beersleo.BeerSearchResult, error)
 	Create(beer *beersleo.BeerDTO) (int, error)
 	Update(beer *beersleo.Beersleo) error
+	Export(filter *beersleo.BeersleoFilter, fn func(beer *beersleo.Beersleo) error) error
+	CreateRevision(rev *beersleo.BeerRevision) error
+	ListRevisions(beerID int) ([]*beersleo.BeerRevision, error)
+	GetRevision(beerID, revision int) (*beersleo.BeerRevision, error)
+	RevisionImages(beerIDs []int) ([]string, error)
+	Audit(entry *audit.Entry) error
+	Transaction(fn func(repo IBeersleoRepository) error) error
+}
+
+// executor is the query surface shared by *sqlx.DB and *sqlx.Tx.
+type executor interface {
+	Get(dest interface{}, query string, args ...interface{}) error
+	Select(dest interface{}, query string, args ...interface{}) error
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	NamedExec(query string, arg interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	Queryx(query string, args ...interface{}) (*sqlx.Rows, error)
 }
 
 type beersleoRepository struct {
-	db *sqlx.DB
+	db   *sqlx.DB
+	exec executor
+	inTx bool
 }
 
 func BeersleoRepository(db *sqlx.DB) IBeersleoRepository {
 	return &beersleoRepository{
-		db: db,
+		db:   db,
+		exec: db,
 	}
 }
 
-func (r *beersleoRepository) GetByID(id int) (*beersleo.Beersleo, error) {
+// Transaction runs fn against a repository bound to a single sqlx.Tx, committing when fn
+// returns nil and rolling back otherwise. Nested calls join the outer transaction.
+func (r *beersleoRepository) Transaction(fn func(repo IBeersleoRepository) error) (err error) {
+	if r.inTx {
+		return fn(r)
+	}
+
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if err = fn(&beersleoRepository{db: r.db, exec: tx, inTx: true}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// notDeleted returns the predicate hiding soft-deleted rows unless the caller asked for them.
+func notDeleted(includeDeleted bool) string {
+	if includeDeleted {
+		return "1=1"
+	}
+	return "deleted_at IS NULL"
+}
+
+func (r *beersleoRepository) GetByID(id int, includeDeleted bool) (*beersleo.Beersleo, error) {
+	defer metrics.ObserveQuery(repositoryName, "GetByID")()
 	var beer beersleo.Beersleo
-	err := r.db.Get(&beer, "SELECT id, name, category, detail, image FROM beers WHERE id=?", id)
+	query := "SELECT id, name, category, category_id, detail, image, version, created_at, updated_at, deleted_at FROM beers WHERE id=? AND " + notDeleted(includeDeleted)
+	err := r.exec.Get(&beer, query, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apperrors.NotFound("Beer with ID %d not found", id)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("Beer with ID %d not found: %v", id, err)
+		return nil, apperrors.Internal(err, "Failed to retrieve beer %d", id)
 	}
 	return &beer, nil
 }
 
+// GetByIDs returns the beers with the given IDs, deleted ones included, ordered by ID.
+func (r *beersleoRepository) GetByIDs(ids []int) ([]*beersleo.Beersleo, error) {
+	defer metrics.ObserveQuery(repositoryName, "GetByIDs")()
+	beers := make([]*beersleo.Beersleo, 0, len(ids))
+	if len(ids) == 0 {
+		return beers, nil
+	}
+	query, args, err := sqlx.In("SELECT id, name, category, category_id, detail, image, version, created_at, updated_at, deleted_at FROM beers WHERE id IN (?) ORDER BY id", ids)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.exec.Select(&beers, query, args...); err != nil {
+		return nil, apperrors.Internal(err, "Failed to retrieve beers")
+	}
+	return beers, nil
+}
+
+// GetByName returns the beers that are not deleted and carry name, compared with the column's collation.
+func (r *beersleoRepository) GetByName(name string) ([]*beersleo.Beersleo, error) {
+	defer metrics.ObserveQuery(repositoryName, "GetByName")()
+	beers := make([]*beersleo.Beersleo, 0)
+	query := "SELECT id, name, category, category_id, detail, image, version, created_at, updated_at, deleted_at FROM beers WHERE name=? AND deleted_at IS NULL ORDER BY id"
+	if err := r.exec.Select(&beers, query, name); err != nil {
+		return nil, apperrors.Internal(err, "Failed to look up beers named %q", name)
+	}
+	return beers, nil
+}
+
 func (r *beersleoRepository) Create(beer *beersleo.BeerDTO) (int, error) {
-	result, err := r.db.NamedExec("INSERT INTO beers(name, category, detail, image) VALUES (:name, :category, :detail, :image)", beer)
+	defer metrics.ObserveQuery(repositoryName, "Create")()
+	result, err := r.exec.NamedExec("INSERT INTO beers(name, category, category_id, detail, image) VALUES (:name, :category, :category_id, :detail, :image)", beer)
 
 	if err != nil {
 		// ถ้ามีข้อผิดพลาด ส่งคืนค่า 0 และ err
@@ -50,58 +156,509 @@ func (r *beersleoRepository) Create(beer *beersleo.BeerDTO) (int, error) {
 	return int(lastInsertID), nil
 }
 
+// Update saves the beer only if its row still has beer.Version, then bumps the version.
+// A concurrent change in between makes it fail with a precondition error.
 func (r *beersleoRepository) Update(beer *beersleo.Beersleo) error {
-	_, err := r.db.NamedExec("UPDATE beers SET name=:name, category=:category, detail=:detail, image=:image WHERE id=:id",
-		&beer)
-	return err
+	defer metrics.ObserveQuery(repositoryName, "Update")()
+	result, err := r.exec.NamedExec("UPDATE beers SET name=:name, category=:category, category_id=:category_id, detail=:detail, image=:image, version=version+1 WHERE id=:id AND version=:version AND deleted_at IS NULL",
+		beer)
+	if err != nil {
+		return err
+	}
+	if err := requireAffected(result, apperrors.PreconditionFailed("Beer with ID %d was modified or deleted by another request", beer.ID)); err != nil {
+		return err
+	}
+	beer.Version++
+	return nil
 }
 
-func (r *beersleoRepository) Delete(id int) error {
-	_, err := r.db.Exec("DELETE FROM beers WHERE id=?", id)
-	return err
+// Delete soft-deletes the beer. A non-zero version makes the delete conditional on it.
+func (r *beersleoRepository) Delete(id int, version int) error {
+	defer metrics.ObserveQuery(repositoryName, "Delete")()
+	if version == 0 {
+		result, err := r.exec.Exec("UPDATE beers SET deleted_at=NOW(), version=version+1 WHERE id=? AND deleted_at IS NULL", id)
+		if err != nil {
+			return err
+		}
+		return requireAffected(result, apperrors.NotFound("Beer with ID %d not found", id))
+	}
+
+	result, err := r.exec.Exec("UPDATE beers SET deleted_at=NOW(), version=version+1 WHERE id=? AND version=? AND deleted_at IS NULL", id, version)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		if _, err := r.GetByID(id, false); err != nil {
+			return err
+		}
+		return apperrors.PreconditionFailed("Beer with ID %d was modified by another request", id)
+	}
+	return nil
 }
 
-func (r *beersleoRepository) GetAllBeersWithPagination(page, limit int) ([]*beersleo.Beersleo, int, error) {
+func (r *beersleoRepository) Restore(id int) error {
+	defer metrics.ObserveQuery(repositoryName, "Restore")()
+	result, err := r.exec.Exec("UPDATE beers SET deleted_at=NULL, version=version+1 WHERE id=? AND deleted_at IS NOT NULL", id)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result, apperrors.NotFound("Deleted beer with ID %d not found", id))
+}
 
+// Purge hard-deletes beers soft-deleted before deletedBefore, and with them their revisions. It
+// returns the removed rows and the images of their revisions, so the images can be cleaned up
+// and the removal audited. Call it inside Transaction: the rows are locked when read, so a
+// concurrent restore waits and every returned row really is deleted.
+func (r *beersleoRepository) Purge(deletedBefore time.Time) ([]*beersleo.Beersleo, []string, error) {
+	defer metrics.ObserveQuery(repositoryName, "Purge")()
 	var beers []*beersleo.Beersleo
+	err := r.exec.Select(&beers, "SELECT id, name, category, category_id, detail, image, version, created_at, updated_at, deleted_at FROM beers WHERE deleted_at IS NOT NULL AND deleted_at < ? FOR UPDATE", deletedBefore)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(beers) == 0 {
+		return nil, nil, nil
+	}
 
-	offset := (page - 1) * limit
+	ids := make([]int, 0, len(beers))
+	for _, beer := range beers {
+		ids = append(ids, beer.ID)
+	}
 
-	var total int
+	revisionImages, err := r.RevisionImages(ids)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	err := r.db.Get(&total, "SELECT COUNT(*) FROM beers")
+	query, args, err := sqlx.In("DELETE FROM beers WHERE id IN (?) AND deleted_at IS NOT NULL", ids)
 	if err != nil {
-		// ถ้าเกิดข้อผิดพลาด ส่งคืนค่า nil, 0, และ err
-		return nil, 0, err
+		return nil, nil, err
+	}
+	result, err := r.exec.Exec(query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Without the lock (outside a transaction) a restored row would survive while its images
+	// were cleaned up; refuse rather than report rows that were not deleted.
+	if affected, err := result.RowsAffected(); err != nil {
+		return nil, nil, err
+	} else if affected != int64(len(beers)) {
+		return nil, nil, apperrors.Conflict("Deleted beers changed while being purged, retry")
 	}
 
-	err = r.db.Select(&beers, "SELECT * FROM beers LIMIT ? OFFSET ?", limit, offset)
+	return beers, revisionImages, nil
+}
+
+func (r *beersleoRepository) CreateRevision(rev *beersleo.BeerRevision) error {
+	defer metrics.ObserveQuery(repositoryName, "CreateRevision")()
+	_, err := r.exec.NamedExec(`INSERT INTO beer_revisions(beer_id, revision, name, category, category_id, detail, image, created_by)
+		VALUES (:beer_id, :revision, :name, :category, :category_id, :detail, :image, :created_by)`, rev)
 	if err != nil {
-		// ถ้าเกิดข้อผิดพลาด ส่งคืนค่า nil, 0, และข้อผิดพลาดที่มีการระบุเพิ่มเติม
-		return nil, 0, fmt.Errorf("error fetching beers with pagination: %w", err)
+		return apperrors.Internal(err, "Failed to save revision %d of beer %d", rev.Revision, rev.BeerID)
 	}
+	return nil
+}
 
-	fmt.Printf("Limit: %d, Offset: %d\n", limit, offset)
+// ListRevisions returns the revisions of the beer, newest first.
+func (r *beersleoRepository) ListRevisions(beerID int) ([]*beersleo.BeerRevision, error) {
+	defer metrics.ObserveQuery(repositoryName, "ListRevisions")()
+	revisions := make([]*beersleo.BeerRevision, 0)
+	if err := r.exec.Select(&revisions, "SELECT * FROM beer_revisions WHERE beer_id=? ORDER BY revision DESC", beerID); err != nil {
+		return nil, apperrors.Internal(err, "Failed to list revisions of beer %d", beerID)
+	}
+	return revisions, nil
+}
 
-	return beers, total, nil
+func (r *beersleoRepository) GetRevision(beerID, revision int) (*beersleo.BeerRevision, error) {
+	defer metrics.ObserveQuery(repositoryName, "GetRevision")()
+	var rev beersleo.BeerRevision
+	err := r.exec.Get(&rev, "SELECT * FROM beer_revisions WHERE beer_id=? AND revision=?", beerID, revision)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apperrors.NotFound("Revision %d of beer %d not found", revision, beerID)
+	}
+	if err != nil {
+		return nil, apperrors.Internal(err, "Failed to retrieve revision %d of beer %d", revision, beerID)
+	}
+	return &rev, nil
 }
-func (r *beersleoRepository) FilterBeersByName(req *beersleo.BeersleoFilter) ([]*beersleo.Beersleo, error) {
-	var beerList []*beersleo.Beersleo
-	query := `SELECT id, name, category, detail, image, created_at, updated_at, deleted_at FROM beers WHERE name LIKE ?`
-	rows, err := r.db.Query(query, "%"+req.Name+"%")
+
+// RevisionImages returns the distinct images the revisions of the beers refer to.
+func (r *beersleoRepository) RevisionImages(beerIDs []int) ([]string, error) {
+	defer metrics.ObserveQuery(repositoryName, "RevisionImages")()
+	images := make([]string, 0)
+	if len(beerIDs) == 0 {
+		return images, nil
+	}
+	query, args, err := sqlx.In("SELECT DISTINCT image FROM beer_revisions WHERE beer_id IN (?) AND image <> ''", beerIDs)
 	if err != nil {
 		return nil, err
 	}
+	if err := r.exec.Select(&images, query, args...); err != nil {
+		return nil, apperrors.Internal(err, "Failed to list revision images")
+	}
+	return images, nil
+}
+
+// Audit records entry in the audit log. Called inside Transaction, the entry is committed or
+// rolled back together with the change it describes.
+func (r *beersleoRepository) Audit(entry *audit.Entry) error {
+	defer metrics.ObserveQuery(repositoryName, "Audit")()
+	_, err := r.exec.NamedExec(`INSERT INTO audit_log(actor_kind, actor_id, actor_name, action, beer_id, request_id, client_ip, before_json, after_json)
+		VALUES (:actor_kind, :actor_id, :actor_name, :action, :beer_id, :request_id, :client_ip, :before_json, :after_json)`, entry)
+	if err != nil {
+		return apperrors.Internal(err, "Failed to write audit log")
+	}
+	return nil
+}
+
+// RecordRewrite runs rewrite, a change another module makes to the beers with the given IDs
+// inside tx (renaming or merging their category), and keeps a revision and an audit entry for
+// every beer it changed on behalf of actor, in the same transaction. The caller has locked the beers.
+func RecordRewrite(tx *sqlx.Tx, actor *audit.Actor, beerIDs []int, rewrite func() error) error {
+	repo := &beersleoRepository{exec: tx, inTx: true}
+	before, err := repo.GetByIDs(beerIDs)
+	if err != nil {
+		return err
+	}
+	if err := rewrite(); err != nil {
+		return err
+	}
+	after, err := repo.GetByIDs(beerIDs)
+	if err != nil {
+		return err
+	}
+
+	changed := make(map[int]*beersleo.Beersleo, len(after))
+	for _, beer := range after {
+		changed[beer.ID] = beer
+	}
+	for _, old := range before {
+		beer := changed[old.ID]
+		if beer == nil || beer.Version == old.Version {
+			continue
+		}
+		if rev := beersleo.NewBeerRevision(old, actor.ID); len(beersleo.DiffRevision(rev, beer)) > 0 {
+			if err := repo.CreateRevision(rev); err != nil {
+				return err
+			}
+		}
+		entry, err := actor.Entry(audit.ActionBeerUpdate, beer.ID, old, beer)
+		if err != nil {
+			return apperrors.Internal(err, "Failed to record audit entry")
+		}
+		if err := repo.Audit(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requireAffected returns notFound when the statement matched no rows.
+func requireAffected(result sql.Result, notFound error) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return notFound
+	}
+	return nil
+}
+
+func (r *beersleoRepository) GetAllBeersWithPagination(filter *beersleo.BeersleoFilter) ([]*beersleo.Beersleo, int, error) {
+	defer metrics.ObserveQuery(repositoryName, "GetAllBeersWithPagination")()
+
+	var beers []*beersleo.Beersleo
+
+	limit, offset := filter.Window()
+
+	where, args := buildBeerWhere(filter)
+	orderBy := buildBeerOrderBy(filter.Sort, false)
+
+	var total int
+
+	if !filter.SkipCount {
+		err := r.exec.Get(&total, "SELECT COUNT(*) FROM beers WHERE "+where, args...)
+		if err != nil {
+			// ถ้าเกิดข้อผิดพลาด ส่งคืนค่า nil, 0, และ err
+			return nil, 0, err
+		}
+	}
 
+	query := "SELECT * FROM beers WHERE " + where + " ORDER BY " + orderBy + " LIMIT ? OFFSET ?"
+	err := r.exec.Select(&beers, query, append(args, limit, offset)...)
+	if err != nil {
+		// ถ้าเกิดข้อผิดพลาด ส่งคืนค่า nil, 0, และข้อผิดพลาดที่มีการระบุเพิ่มเติม
+		return nil, 0, fmt.Errorf("error fetching beers with pagination: %w", err)
+	}
+
+	return beers, total, nil
+}
+
+// Export passes the beers matching filter to fn one at a time as they are read from the
+// cursor, so the result set never has to fit in memory. An error from fn stops the export.
+func (r *beersleoRepository) Export(filter *beersleo.BeersleoFilter, fn func(beer *beersleo.Beersleo) error) error {
+	defer metrics.ObserveQuery(repositoryName, "Export")()
+	where, args := buildBeerWhere(filter)
+	query := "SELECT * FROM beers WHERE " + where + " ORDER BY " + buildBeerOrderBy(filter.Sort, false)
+
+	rows, err := r.exec.Queryx(query, args...)
+	if err != nil {
+		return apperrors.Internal(err, "Failed to export beers")
+	}
 	defer rows.Close()
+
 	for rows.Next() {
 		var beer beersleo.Beersleo
-		if err := rows.Scan(&beer.ID, &beer.Name, &beer.Category, &beer.Detail, &beer.Image, &beer.CreatedAt, &beer.UpdatedAt, &beer.DeletedAt); err != nil {
-			return nil, err
+		if err := rows.StructScan(&beer); err != nil {
+			return apperrors.Internal(err, "Failed to export beers")
+		}
+		if err := fn(&beer); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return apperrors.Internal(err, "Failed to export beers")
+	}
+	return nil
+}
+
+// matchAgainst is the full-text predicate backed by the ft_beers_search ngram index.
+const matchAgainst = "MATCH(name, category, detail) AGAINST (? IN NATURAL LANGUAGE MODE)"
+
+// relevance is the score beers are ranked by. MATCH returns a float that does not survive a
+// round trip through a cursor, so it is fixed to six decimals for exact keyset comparisons.
+const relevance = "CAST(" + matchAgainst + " AS " + scoreType + ")"
+
+const scoreType = "DECIMAL(20,6)"
+
+// Search runs a full-text query ranked by relevance; the other filter fields still apply.
+func (r *beersleoRepository) Search(filter *beersleo.BeersleoFilter) ([]*beersleo.BeerSearchResult, int, error) {
+	defer metrics.ObserveQuery(repositoryName, "Search")()
+	var results []*beersleo.BeerSearchResult
+
+	limit, offset := filter.Window()
+
+	where, args := buildBeerWhere(filter)
+
+	var total int
+	if !filter.SkipCount {
+		if err := r.exec.Get(&total, "SELECT COUNT(*) FROM beers WHERE "+where, args...); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	orderBy := "score DESC, id"
+	if len(filter.Sort) > 0 {
+		orderBy = buildBeerOrderBy(filter.Sort, false)
+	}
+
+	query := "SELECT *, " + relevance + " AS score FROM beers WHERE " + where + " ORDER BY " + orderBy + " LIMIT ? OFFSET ?"
+	queryArgs := append([]interface{}{filter.Query}, args...)
+	if err := r.exec.Select(&results, query, append(queryArgs, limit, offset)...); err != nil {
+		return nil, 0, fmt.Errorf("error searching beers: %w", err)
+	}
+
+	return results, total, nil
+}
+
+// GetAllBeersWithKeyset returns up to filter.Limit beers following filter.Keyset in the
+// order of filter.Sort, which must be normalized. Backward pages come back in reverse order.
+func (r *beersleoRepository) GetAllBeersWithKeyset(filter *beersleo.BeersleoFilter) ([]*beersleo.Beersleo, error) {
+	defer metrics.ObserveQuery(repositoryName, "GetAllBeersWithKeyset")()
+	var beers []*beersleo.Beersleo
+
+	where, args := buildBeerWhere(filter)
+	keyset, keysetArgs := buildKeyset(filter)
+	backward := filter.Keyset != nil && filter.Keyset.Backward
+
+	query := "SELECT * FROM beers WHERE " + where + " AND " + keyset +
+		" ORDER BY " + buildBeerOrderBy(filter.Sort, backward) + " LIMIT ?"
+	args = append(append(args, keysetArgs...), filter.Limit)
+	if err := r.exec.Select(&beers, query, args...); err != nil {
+		return nil, apperrors.Internal(err, "Failed to list beers")
+	}
+
+	return beers, nil
+}
+
+// SearchWithKeyset is the keyset-paginated variant of Search.
+func (r *beersleoRepository) SearchWithKeyset(filter *beersleo.BeersleoFilter) ([]*beersleo.BeerSearchResult, error) {
+	defer metrics.ObserveQuery(repositoryName, "SearchWithKeyset")()
+	var results []*beersleo.BeerSearchResult
+
+	where, args := buildBeerWhere(filter)
+	keyset, keysetArgs := buildKeyset(filter)
+	backward := filter.Keyset != nil && filter.Keyset.Backward
+
+	query := "SELECT *, " + relevance + " AS score FROM beers WHERE " + where + " AND " + keyset +
+		" ORDER BY " + buildBeerOrderBy(filter.Sort, backward) + " LIMIT ?"
+	queryArgs := append([]interface{}{filter.Query}, args...)
+	queryArgs = append(append(queryArgs, keysetArgs...), filter.Limit)
+	if err := r.exec.Select(&results, query, queryArgs...); err != nil {
+		return nil, apperrors.Internal(err, "Failed to search beers")
+	}
+
+	return results, nil
+}
+
+// buildKeyset expands the position into (a > ?) OR (a = ? AND b > ?) OR ..., flipping each
+// comparison for descending fields and for backward pages.
+func buildKeyset(filter *beersleo.BeersleoFilter) (string, []interface{}) {
+	if filter.Keyset == nil || len(filter.Keyset.Values) == 0 {
+		return "1=1", nil
+	}
+
+	var (
+		alternatives []string
+		args         []interface{}
+	)
+	for i, field := range filter.Sort {
+		var parts []string
+		for j := 0; j < i; j++ {
+			column, placeholder, columnArgs := keysetColumn(filter.Sort[j].Field, filter)
+			parts = append(parts, column+" = "+placeholder)
+			args = append(append(args, columnArgs...), filter.Keyset.Values[j])
 		}
 
-		beerList = append(beerList, &beer)
+		op := ">"
+		if field.Desc != filter.Keyset.Backward {
+			op = "<"
+		}
+		column, placeholder, columnArgs := keysetColumn(field.Field, filter)
+		parts = append(parts, column+" "+op+" "+placeholder)
+		args = append(append(args, columnArgs...), filter.Keyset.Values[i])
+
+		alternatives = append(alternatives, "("+strings.Join(parts, " AND ")+")")
+	}
+
+	return "(" + strings.Join(alternatives, " OR ") + ")", args
+}
+
+// keysetColumn is the expression a sort field compares on and the placeholder for its cursor
+// value. The score alias cannot be used in WHERE, so it is recomputed from the query, and the
+// cursor value is cast to the same decimal so ties compare exactly.
+func keysetColumn(field string, filter *beersleo.BeersleoFilter) (string, string, []interface{}) {
+	if field == "score" {
+		return relevance, "CAST(? AS " + scoreType + ")", []interface{}{filter.Query}
+	}
+	return sortColumns[field], "?", nil
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func contains(value string) string {
+	return "%" + likeEscaper.Replace(value) + "%"
+}
+
+// buildBeerWhere turns the filter into a parameterized WHERE clause.
+func buildBeerWhere(filter *beersleo.BeersleoFilter) (string, []interface{}) {
+	conditions := []string{notDeleted(filter.IncludeDeleted)}
+	var args []interface{}
+
+	if filter.Query != "" {
+		conditions = append(conditions, matchAgainst)
+		args = append(args, filter.Query)
 	}
 
-	return beerList, nil
+	if filter.Name != "" {
+		conditions = append(conditions, "name LIKE ?")
+		args = append(args, contains(filter.Name))
+	}
+	if filter.Detail != "" {
+		conditions = append(conditions, "detail LIKE ?")
+		args = append(args, contains(filter.Detail))
+	}
+	if len(filter.Categories) > 0 || len(filter.CategoryIDs) > 0 {
+		var roots []string
+		if len(filter.Categories) > 0 {
+			placeholders := strings.TrimSuffix(strings.Repeat("?,", len(filter.Categories)), ",")
+			roots = append(roots, "slug IN ("+placeholders+")", "LOWER(name) IN ("+placeholders+")")
+			for _, category := range filter.Categories {
+				args = append(args, categories.Slugify(category))
+			}
+			for _, category := range filter.Categories {
+				args = append(args, strings.ToLower(category))
+			}
+		}
+		if len(filter.CategoryIDs) > 0 {
+			roots = append(roots, "id IN ("+strings.TrimSuffix(strings.Repeat("?,", len(filter.CategoryIDs)), ",")+")")
+			for _, id := range filter.CategoryIDs {
+				args = append(args, id)
+			}
+		}
+		// The selected categories and everything below them.
+		conditions = append(conditions, "category_id IN (WITH RECURSIVE tree (id) AS ("+
+			"SELECT id FROM categories WHERE "+strings.Join(roots, " OR ")+
+			" UNION ALL SELECT c.id FROM categories c JOIN tree ON c.parent_id = tree.id"+
+			") SELECT id FROM tree)")
+	}
+	if filter.CreatedFrom != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, *filter.CreatedFrom)
+	}
+	if filter.CreatedTo != nil {
+		conditions = append(conditions, "created_at <= ?")
+		args = append(args, *filter.CreatedTo)
+	}
+	if filter.UpdatedFrom != nil {
+		conditions = append(conditions, "updated_at >= ?")
+		args = append(args, *filter.UpdatedFrom)
+	}
+	if filter.UpdatedTo != nil {
+		conditions = append(conditions, "updated_at <= ?")
+		args = append(args, *filter.UpdatedTo)
+	}
+	if filter.HasImage != nil {
+		if *filter.HasImage {
+			conditions = append(conditions, "image <> ''")
+		} else {
+			conditions = append(conditions, "image = ''")
+		}
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+// sortColumns whitelists the columns the list can be ordered by.
+var sortColumns = map[string]string{
+	"id":         "id",
+	"name":       "name",
+	"category":   "category",
+	"created_at": "created_at",
+	"updated_at": "updated_at",
+	// score is the relevance alias selected by the search queries.
+	"score": "score",
+}
+
+// buildBeerOrderBy builds the ORDER BY list, always ending with id so pages are stable.
+// reverse flips every direction, which backward keyset pages need.
+func buildBeerOrderBy(sort []beersleo.SortField, reverse bool) string {
+	var columns []string
+	hasID := false
+	for _, field := range sort {
+		column, ok := sortColumns[field.Field]
+		if !ok {
+			continue
+		}
+		if column == "id" {
+			hasID = true
+		}
+		if field.Desc != reverse {
+			column += " DESC"
+		}
+		columns = append(columns, column)
+	}
+	if !hasID {
+		if reverse {
+			columns = append(columns, "id DESC")
+		} else {
+			columns = append(columns, "id")
+		}
+	}
+	return strings.Join(columns, ", ")
 }
@@ -1,83 +1,847 @@
 package beersleoUsecases
 
 import (
-	"errors"
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/peedans/beerleo/modules/audit"
 	"github.com/peedans/beerleo/modules/beersleo"
 	"github.com/peedans/beerleo/modules/beersleo/beersleoRepositories"
+	"github.com/peedans/beerleo/modules/categories"
+	"github.com/peedans/beerleo/modules/categories/categoriesRepositories"
+	"github.com/peedans/beerleo/pkg/apperrors"
+	"github.com/peedans/beerleo/pkg/auth"
+	"github.com/peedans/beerleo/pkg/highlights"
+	"github.com/peedans/beerleo/pkg/logger"
+	"github.com/peedans/beerleo/pkg/metrics"
+	"github.com/peedans/beerleo/pkg/storages"
+	"github.com/peedans/beerleo/pkg/uploads"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
+// IBeersleoUsecase checks every call against the principal carried by ctx, see auth.WithPrincipal.
 type IBeersleoUsecase interface {
-	GetBeerByID(id int) (*beersleo.Beersleo, error)
-	DeleteBeer(id int) error
-	FilterBeersByName(req *beersleo.BeersleoFilter) ([]*beersleo.BeerDTO, error)
-	GetAllBeersPagination(page, limit int) ([]*beersleo.Beersleo, int, error)
-	CreateBeer(beer *beersleo.BeerDTO) (int, error)
-	UpdateBeer(beer *beersleo.Beersleo) error
+	GetBeerByID(ctx context.Context, id int, includeDeleted bool) (*beersleo.Beersleo, error)
+	DeleteBeer(ctx context.Context, id int, version int) error
+	RestoreBeer(ctx context.Context, id int) error
+	PurgeDeletedBeers(ctx context.Context, retention time.Duration) (int, error)
+	GetAllBeersPagination(ctx context.Context, filter *beersleo.BeersleoFilter) ([]*beersleo.Beersleo, int, error)
+	GetAllBeersCursor(ctx context.Context, filter *beersleo.BeersleoFilter) ([]*beersleo.Beersleo, bool, error)
+	SearchBeers(ctx context.Context, filter *beersleo.BeersleoFilter) ([]*beersleo.BeerSearchResult, int, error)
+	SearchBeersCursor(ctx context.Context, filter *beersleo.BeersleoFilter) ([]*beersleo.BeerSearchResult, bool, error)
+	CreateBeer(ctx context.Context, beer *beersleo.BeerDTO, image *uploads.Image) (*beersleo.Beersleo, error)
+	PatchBeer(ctx context.Context, id int, patch *beersleo.BeerPatch, image *uploads.Image) (*beersleo.Beersleo, error)
+	ImportBeers(ctx context.Context, rows []*beersleo.BeerImportRow, opts *beersleo.BeerImportOptions) (*beersleo.BeerImportReport, error)
+	ExportBeers(ctx context.Context, filter *beersleo.BeersleoFilter, fn func(beer *beersleo.Beersleo) error) error
+	ListBeerRevisions(ctx context.Context, id int) ([]*beersleo.BeerRevision, error)
+	GetBeerRevision(ctx context.Context, id, revision int) (*beersleo.BeerRevisionDiff, error)
+	RevertBeer(ctx context.Context, id, revision, version int) (*beersleo.Beersleo, error)
 }
 
 type beersleoUsecase struct {
-	beersleoRepository beersleoRepositories.IBeersleoRepository
+	beersleoRepository   beersleoRepositories.IBeersleoRepository
+	categoriesRepository categoriesRepositories.ICategoriesRepository
+	imageStore           storages.IImageStore
 }
 
-func BeersleoUsecase(beersleoRepository beersleoRepositories.IBeersleoRepository) IBeersleoUsecase {
+func BeersleoUsecase(beersleoRepository beersleoRepositories.IBeersleoRepository, categoriesRepository categoriesRepositories.ICategoriesRepository, imageStore storages.IImageStore) IBeersleoUsecase {
 	return &beersleoUsecase{
-		beersleoRepository: beersleoRepository,
+		beersleoRepository:   beersleoRepository,
+		categoriesRepository: categoriesRepository,
+		imageStore:           imageStore,
 	}
 }
 
-var ErrInvalidBeerID = errors.New("invalid beer ID provided")
+// authorizeRead checks the permission to read beers and, for deleted ones, to see the trash.
+func authorizeRead(ctx context.Context, includeDeleted bool) error {
+	if err := auth.Authorize(ctx, auth.PermBeersRead); err != nil {
+		return err
+	}
+	if includeDeleted {
+		return auth.Authorize(ctx, auth.PermBeersRestore)
+	}
+	return nil
+}
+
+func (bu *beersleoUsecase) GetBeerByID(ctx context.Context, id int, includeDeleted bool) (*beersleo.Beersleo, error) {
+	if err := authorizeRead(ctx, includeDeleted); err != nil {
+		return nil, err
+	}
+	return bu.beersleoRepository.GetByID(id, includeDeleted)
+}
+
+func (bu *beersleoUsecase) DeleteBeer(ctx context.Context, id int, version int) error {
+	if err := auth.Authorize(ctx, auth.PermBeersDelete); err != nil {
+		return err
+	}
+
+	return bu.beersleoRepository.Transaction(func(repo beersleoRepositories.IBeersleoRepository) error {
+		before, err := repo.GetByID(id, false)
+		if err != nil {
+			return err
+		}
+		if err := repo.Delete(id, version); err != nil {
+			return err
+		}
+		after, err := repo.GetByID(id, true)
+		if err != nil {
+			return err
+		}
+		return recordAudit(ctx, repo, audit.ActionBeerDelete, id, before, after)
+	})
+}
+
+func (bu *beersleoUsecase) RestoreBeer(ctx context.Context, id int) error {
+	if err := auth.Authorize(ctx, auth.PermBeersRestore); err != nil {
+		return err
+	}
 
-func (bu *beersleoUsecase) GetBeerByID(id int) (*beersleo.Beersleo, error) {
-	return bu.beersleoRepository.GetByID(id)
+	return bu.beersleoRepository.Transaction(func(repo beersleoRepositories.IBeersleoRepository) error {
+		before, err := repo.GetByID(id, true)
+		if err != nil {
+			return err
+		}
+		if err := repo.Restore(id); err != nil {
+			return err
+		}
+		after, err := repo.GetByID(id, false)
+		if err != nil {
+			return err
+		}
+		return recordAudit(ctx, repo, audit.ActionBeerRestore, id, before, after)
+	})
 }
 
-func (bu *beersleoUsecase) DeleteBeer(id int) error {
-	return bu.beersleoRepository.Delete(id)
+// PurgeDeletedBeers hard-deletes beers that have been soft-deleted for longer than retention,
+// together with their stored images, and returns how many beers were removed.
+func (bu *beersleoUsecase) PurgeDeletedBeers(ctx context.Context, retention time.Duration) (int, error) {
+	if err := auth.Authorize(ctx, auth.PermBeersDelete); err != nil {
+		return 0, err
+	}
+	var (
+		beers  []*beersleo.Beersleo
+		images []string
+	)
+	err := bu.beersleoRepository.Transaction(func(repo beersleoRepositories.IBeersleoRepository) error {
+		var err error
+		if beers, images, err = repo.Purge(time.Now().Add(-retention)); err != nil {
+			return err
+		}
+		for _, b := range beers {
+			if b.Image != "" {
+				images = append(images, b.Image)
+			}
+			if err := recordAudit(ctx, repo, audit.ActionBeerPurge, b.ID, b, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Replaced images are kept for the revisions, so they only go once the beer is purged.
+	deleted := make(map[string]bool)
+	for _, image := range images {
+		key := beersleo.ImageKey(image)
+		if deleted[key] {
+			continue
+		}
+		deleted[key] = true
+		if err := bu.deleteImages(uploads.ImageKeys(key)); err != nil {
+			return len(beers), err
+		}
+	}
+
+	return len(beers), nil
 }
 
-func (bu *beersleoUsecase) GetAllBeersPagination(page, limit int) ([]*beersleo.Beersleo, int, error) {
+// GetAllBeersPagination returns one page of beers and the total. With filter.SkipCount the
+// total is not counted; it is then only a lower bound that is past the page end when more rows follow.
+func (bu *beersleoUsecase) GetAllBeersPagination(ctx context.Context, filter *beersleo.BeersleoFilter) ([]*beersleo.Beersleo, int, error) {
+	if err := authorizeRead(ctx, filter.IncludeDeleted); err != nil {
+		return nil, 0, err
+	}
 
-	beerResponses, total, err := bu.beersleoRepository.GetAllBeersWithPagination(page, limit)
+	logger.From(ctx).Debug("list beers", "page", filter.Page, "limit", filter.Limit, "skip_count", filter.SkipCount)
+	beerResponses, total, err := bu.beersleoRepository.GetAllBeersWithPagination(filter)
 
 	if err != nil {
 		// ถ้ามีข้อผิดพลาด ส่งคืนค่า nil, 0, และ err
 		return nil, 0, err
 	}
 
+	if filter.SkipCount {
+		total = (filter.Page-1)*filter.Limit + len(beerResponses)
+		if len(beerResponses) > filter.Limit {
+			beerResponses = beerResponses[:filter.Limit]
+		}
+	}
+
 	return beerResponses, total, nil
 }
 
-func (bu *beersleoUsecase) CreateBeer(beer *beersleo.BeerDTO) (int, error) {
-	return bu.beersleoRepository.Create(beer)
+// ExportBeers streams every beer matching filter to fn, in filter.Sort order.
+func (bu *beersleoUsecase) ExportBeers(ctx context.Context, filter *beersleo.BeersleoFilter, fn func(beer *beersleo.Beersleo) error) error {
+	if err := authorizeRead(ctx, filter.IncludeDeleted); err != nil {
+		return err
+	}
+	return bu.beersleoRepository.Export(filter, fn)
+}
+
+// snippetRadius is how many characters of context a highlight keeps on each side of the first match.
+const snippetRadius = 60
+
+// SearchBeers runs a full-text search and highlights the matching fields of every result.
+// The total follows the same filter.SkipCount rule as GetAllBeersPagination.
+func (bu *beersleoUsecase) SearchBeers(ctx context.Context, filter *beersleo.BeersleoFilter) ([]*beersleo.BeerSearchResult, int, error) {
+	if err := authorizeRead(ctx, filter.IncludeDeleted); err != nil {
+		return nil, 0, err
+	}
+	if utf8.RuneCountInString(strings.TrimSpace(filter.Query)) < highlights.NgramSize {
+		return nil, 0, apperrors.Validation("Search query must be at least %d characters", highlights.NgramSize)
+	}
+
+	results, total, err := bu.beersleoRepository.Search(filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if filter.SkipCount {
+		total = (filter.Page-1)*filter.Limit + len(results)
+		if len(results) > filter.Limit {
+			results = results[:filter.Limit]
+		}
+	}
+
+	highlight(results, filter.Query)
+
+	return results, total, nil
+}
+
+// GetAllBeersCursor returns the keyset page described by filter and whether more rows exist
+// beyond it in the direction of travel. filter.Sort must be normalized.
+func (bu *beersleoUsecase) GetAllBeersCursor(ctx context.Context, filter *beersleo.BeersleoFilter) ([]*beersleo.Beersleo, bool, error) {
+	if err := authorizeRead(ctx, filter.IncludeDeleted); err != nil {
+		return nil, false, err
+	}
+	limit := filter.Limit
+	filter.Limit = limit + 1
+	defer func() { filter.Limit = limit }()
+
+	beers, err := bu.beersleoRepository.GetAllBeersWithKeyset(filter)
+	if err != nil {
+		return nil, false, err
+	}
+
+	hasMore := len(beers) > limit
+	if hasMore {
+		beers = beers[:limit]
+	}
+	if filter.Keyset != nil && filter.Keyset.Backward {
+		for i, j := 0, len(beers)-1; i < j; i, j = i+1, j-1 {
+			beers[i], beers[j] = beers[j], beers[i]
+		}
+	}
+
+	return beers, hasMore, nil
+}
+
+// SearchBeersCursor is the keyset-paginated variant of SearchBeers.
+func (bu *beersleoUsecase) SearchBeersCursor(ctx context.Context, filter *beersleo.BeersleoFilter) ([]*beersleo.BeerSearchResult, bool, error) {
+	if err := authorizeRead(ctx, filter.IncludeDeleted); err != nil {
+		return nil, false, err
+	}
+	if utf8.RuneCountInString(strings.TrimSpace(filter.Query)) < highlights.NgramSize {
+		return nil, false, apperrors.Validation("Search query must be at least %d characters", highlights.NgramSize)
+	}
+
+	limit := filter.Limit
+	filter.Limit = limit + 1
+	defer func() { filter.Limit = limit }()
+
+	results, err := bu.beersleoRepository.SearchWithKeyset(filter)
+	if err != nil {
+		return nil, false, err
+	}
+
+	hasMore := len(results) > limit
+	if hasMore {
+		results = results[:limit]
+	}
+	if filter.Keyset != nil && filter.Keyset.Backward {
+		for i, j := 0, len(results)-1; i < j; i, j = i+1, j-1 {
+			results[i], results[j] = results[j], results[i]
+		}
+	}
+
+	highlight(results, filter.Query)
+
+	return results, hasMore, nil
+}
+
+// highlight fills in the snippets of the fields each result matched on.
+func highlight(results []*beersleo.BeerSearchResult, query string) {
+	terms := highlights.Terms(query)
+	for _, result := range results {
+		fields := map[string]string{
+			"name":     result.Name,
+			"category": result.Category,
+			"detail":   result.Detail,
+		}
+		for field, text := range fields {
+			if snippet, ok := highlights.Snippet(text, terms, snippetRadius); ok {
+				if result.Highlights == nil {
+					result.Highlights = make(map[string]string)
+				}
+				result.Highlights[field] = snippet
+			}
+		}
+	}
+}
+
+// CreateBeer inserts the beer and stores its image as one unit of work: the row is only
+// committed once the image is stored, and stored files are removed again if anything fails.
+func (bu *beersleoUsecase) CreateBeer(ctx context.Context, beer *beersleo.BeerDTO, image *uploads.Image) (*beersleo.Beersleo, error) {
+	if err := auth.Authorize(ctx, auth.PermBeersCreate); err != nil {
+		return nil, err
+	}
+	category, err := bu.resolveCategory(beer.Category)
+	if err != nil {
+		return nil, err
+	}
+	beer.Category, beer.CategoryID = category.Name, &category.ID
+
+	var (
+		created *beersleo.Beersleo
+		stored  []string
+	)
+
+	err = bu.beersleoRepository.Transaction(func(repo beersleoRepositories.IBeersleoRepository) error {
+		id, err := repo.Create(beer)
+		if err != nil {
+			return err
+		}
+
+		if image != nil {
+			stored, err = bu.storeImage(id, image)
+			if err != nil {
+				return err
+			}
+			withImage := &beersleo.Beersleo{
+				ID:         id,
+				Name:       beer.Name,
+				Category:   beer.Category,
+				CategoryID: beer.CategoryID,
+				Detail:     beer.Detail,
+				Image:      stored[0],
+				Version:    1,
+			}
+			if err := repo.Update(withImage); err != nil {
+				return err
+			}
+		}
+
+		if created, err = repo.GetByID(id, false); err != nil {
+			return err
+		}
+		return recordAudit(ctx, repo, audit.ActionBeerCreate, id, nil, created)
+	})
+	if err != nil {
+		// ชดเชย: ลบไฟล์ที่บันทึกไปแล้วเมื่อ transaction ไม่สำเร็จ
+		_ = bu.deleteImages(stored)
+		return nil, err
+	}
+
+	return created, nil
+}
+
+// updateBeer saves the beer and, when image is set, replaces its image. The previous image stays
+// in storage for the revision that refers to it; a newly stored image is removed if the update fails.
+func (bu *beersleoUsecase) updateBeer(ctx context.Context, beer *beersleo.Beersleo, image *uploads.Image) error {
+	if image == nil {
+		return bu.saveBeer(ctx, beer)
+	}
+
+	oldImage := beer.Image
+	oldKey := beersleo.ImageKey(oldImage)
+
+	// Re-uploading a file yields the same content-hash key, which must survive a failure when the
+	// beer or one of its revisions already uses it.
+	discard := func(stored []string) {
+		if len(stored) == 0 || stored[0] == oldKey {
+			return
+		}
+		if referenced, err := referencedByRevision(bu.beersleoRepository, beer.ID, stored[0]); err == nil && !referenced {
+			_ = bu.deleteImages(stored)
+		}
+	}
+
+	stored, err := bu.storeImage(beer.ID, image)
+	if err != nil {
+		discard(stored)
+		return err
+	}
+	beer.Image = stored[0]
+
+	if err := bu.saveBeer(ctx, beer); err != nil {
+		discard(stored)
+		beer.Image = oldImage
+		return err
+	}
+	return nil
+}
+
+// saveBeer updates the beer, keeps its previous state as a revision and records the change in
+// the audit log, all in one transaction.
+func (bu *beersleoUsecase) saveBeer(ctx context.Context, beer *beersleo.Beersleo) error {
+	return bu.beersleoRepository.Transaction(func(repo beersleoRepositories.IBeersleoRepository) error {
+		before, err := repo.GetByID(beer.ID, false)
+		if err != nil {
+			return err
+		}
+		if err := saveRevision(ctx, repo, before, beer); err != nil {
+			return err
+		}
+		if err := repo.Update(beer); err != nil {
+			return err
+		}
+		after, err := repo.GetByID(beer.ID, false)
+		if err != nil {
+			return err
+		}
+		beer.UpdatedAt = after.UpdatedAt
+		return recordAudit(ctx, repo, audit.ActionBeerUpdate, beer.ID, before, after)
+	})
+}
+
+// PatchBeer applies the supplied fields to the beer. A new image replaces the current one;
+// otherwise the current image is kept unless RemoveImage is set. It is the one write path for
+// both PUT, which supplies every field, and PATCH; each save goes through saveBeer.
+func (bu *beersleoUsecase) PatchBeer(ctx context.Context, id int, patch *beersleo.BeerPatch, image *uploads.Image) (*beersleo.Beersleo, error) {
+	if err := auth.Authorize(ctx, auth.PermBeersUpdate); err != nil {
+		return nil, err
+	}
+	beer, err := bu.beersleoRepository.GetByID(id, false)
+	if err != nil {
+		return nil, err
+	}
+	if patch.Version != 0 && patch.Version != beer.Version {
+		return nil, apperrors.PreconditionFailed("Beer with ID %d has changed, current version is %d", id, beer.Version)
+	}
+
+	if patch.Name != nil {
+		beer.Name = *patch.Name
+	}
+	if patch.Category != nil {
+		category, err := bu.resolveCategory(*patch.Category)
+		if err != nil {
+			return nil, err
+		}
+		beer.Category, beer.CategoryID = category.Name, &category.ID
+	}
+	if patch.Detail != nil {
+		beer.Detail = *patch.Detail
+	}
+
+	if image != nil || !patch.RemoveImage || beer.Image == "" {
+		if err := bu.updateBeer(ctx, beer, image); err != nil {
+			return nil, err
+		}
+		return beer, nil
+	}
+
+	// The file stays in storage for the revision that still shows it.
+	beer.Image = ""
+	if err := bu.saveBeer(ctx, beer); err != nil {
+		return nil, err
+	}
+	return beer, nil
+}
+
+// ListBeerRevisions returns the saved revisions of the beer, newest first.
+func (bu *beersleoUsecase) ListBeerRevisions(ctx context.Context, id int) ([]*beersleo.BeerRevision, error) {
+	if err := auth.Authorize(ctx, auth.PermBeersRead); err != nil {
+		return nil, err
+	}
+	if _, err := bu.beersleoRepository.GetByID(id, false); err != nil {
+		return nil, err
+	}
+	return bu.beersleoRepository.ListRevisions(id)
+}
+
+// GetBeerRevision returns a revision with the fields in which it differs from the current beer.
+func (bu *beersleoUsecase) GetBeerRevision(ctx context.Context, id, revision int) (*beersleo.BeerRevisionDiff, error) {
+	if err := auth.Authorize(ctx, auth.PermBeersRead); err != nil {
+		return nil, err
+	}
+	beer, err := bu.beersleoRepository.GetByID(id, false)
+	if err != nil {
+		return nil, err
+	}
+	rev, err := bu.beersleoRepository.GetRevision(id, revision)
+	if err != nil {
+		return nil, err
+	}
+	return &beersleo.BeerRevisionDiff{Revision: rev, Current: beer, Diff: beersleo.DiffRevision(rev, beer)}, nil
 }
 
-func (bu *beersleoUsecase) UpdateBeer(beer *beersleo.Beersleo) error {
-	if beer.ID == 0 {
-		return ErrInvalidBeerID
+// RevertBeer puts the fields of a revision back. The revert is an update like any other, so the
+// state it replaces becomes a revision in turn. A non-zero version must match the current one.
+func (bu *beersleoUsecase) RevertBeer(ctx context.Context, id, revision, version int) (*beersleo.Beersleo, error) {
+	if err := auth.Authorize(ctx, auth.PermBeersUpdate); err != nil {
+		return nil, err
+	}
+	beer, err := bu.beersleoRepository.GetByID(id, false)
+	if err != nil {
+		return nil, err
+	}
+	if version != 0 && version != beer.Version {
+		return nil, apperrors.PreconditionFailed("Beer with ID %d has changed, current version is %d", id, beer.Version)
+	}
+	rev, err := bu.beersleoRepository.GetRevision(id, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	// The category may have been renamed or merged since; follow its ID, or else its name.
+	var category *categories.Category
+	if rev.CategoryID != nil {
+		category, err = bu.categoriesRepository.GetByID(*rev.CategoryID)
+		if err != nil && !apperrors.Is(err, apperrors.KindNotFound) {
+			return nil, err
+		}
+	}
+	if category == nil {
+		if category, err = bu.resolveCategory(rev.Category); err != nil {
+			return nil, err
+		}
+	}
+
+	beer.Name, beer.Category, beer.CategoryID = rev.Name, category.Name, &category.ID
+	beer.Detail, beer.Image = rev.Detail, rev.Image
+	if err := bu.saveBeer(ctx, beer); err != nil {
+		return nil, err
 	}
-	return bu.beersleoRepository.Update(beer)
+	return beer, nil
 }
 
-func (bu *beersleoUsecase) FilterBeersByName(req *beersleo.BeersleoFilter) ([]*beersleo.BeerDTO, error) {
+// ImportBeers validates the rows and writes the valid ones in transactions of opts.ChunkSize
+// rows. A chunk that fails is rolled back as a whole and its rows are reported as failed;
+// chunks written before it are kept.
+func (bu *beersleoUsecase) ImportBeers(ctx context.Context, rows []*beersleo.BeerImportRow, opts *beersleo.BeerImportOptions) (*beersleo.BeerImportReport, error) {
+	if err := auth.Authorize(ctx, auth.PermBeersImport); err != nil {
+		return nil, err
+	}
+	if opts.Upsert {
+		if err := auth.Authorize(ctx, auth.PermBeersUpdate); err != nil {
+			return nil, err
+		}
+	}
+	report := &beersleo.BeerImportReport{
+		DryRun: opts.DryRun,
+		Upsert: opts.Upsert,
+		Total:  len(rows),
+		Rows:   make([]*beersleo.BeerImportResult, len(rows)),
+	}
 
-	beersByName, err := bu.beersleoRepository.FilterBeersByName(req)
+	resolved := make(map[string]*categories.Category)
+	var valid []int
+	for i, row := range rows {
+		report.Rows[i] = &beersleo.BeerImportResult{Row: row.Row, Line: row.Line, Name: row.Name}
+		if len(row.Errors) == 0 {
+			key := strings.ToLower(strings.TrimSpace(row.Category))
+			category, ok := resolved[key]
+			if !ok {
+				var err error
+				category, err = bu.resolveCategory(row.Category)
+				if err != nil && !apperrors.Is(err, apperrors.KindValidation) {
+					return nil, err
+				}
+				resolved[key] = category
+			}
+			if category == nil {
+				row.Errors = map[string]string{"category": fmt.Sprintf("unknown category %q", row.Category)}
+			} else {
+				row.Category = category.Name
+				row.CategoryID = category.ID
+			}
+		}
+		if len(row.Errors) > 0 {
+			report.Rows[i].Status = beersleo.ImportFailed
+			report.Rows[i].Errors = row.Errors
+			continue
+		}
+		valid = append(valid, i)
+	}
 
+	if opts.DryRun {
+		if err := bu.planImport(rows, valid, opts, report); err != nil {
+			return nil, err
+		}
+	} else {
+		for start := 0; start < len(valid); start += opts.ChunkSize {
+			end := start + opts.ChunkSize
+			if end > len(valid) {
+				end = len(valid)
+			}
+			bu.importChunk(ctx, rows, valid[start:end], opts, report)
+		}
+	}
+
+	for _, result := range report.Rows {
+		switch result.Status {
+		case beersleo.ImportCreated:
+			report.Created++
+		case beersleo.ImportUpdated:
+			report.Updated++
+		default:
+			report.Failed++
+		}
+	}
+	return report, nil
+}
+
+// planImport fills in the report of a dry run: what each valid row would do.
+func (bu *beersleoUsecase) planImport(rows []*beersleo.BeerImportRow, valid []int, opts *beersleo.BeerImportOptions, report *beersleo.BeerImportReport) error {
+	seen := make(map[string]bool)
+	for _, i := range valid {
+		result := report.Rows[i]
+		result.Status = beersleo.ImportCreated
+		if !opts.Upsert {
+			continue
+		}
+
+		// A name repeated in the file updates the beer its first occurrence creates.
+		name := strings.ToLower(rows[i].Name)
+		if seen[name] {
+			result.Status = beersleo.ImportUpdated
+			continue
+		}
+		seen[name] = true
+
+		matches, err := bu.beersleoRepository.GetByName(rows[i].Name)
+		if err != nil {
+			return err
+		}
+		switch {
+		case len(matches) == 1:
+			result.Status = beersleo.ImportUpdated
+			result.ID = matches[0].ID
+		case len(matches) > 1:
+			result.Status = beersleo.ImportFailed
+			result.Errors = ambiguousName(len(matches))
+		}
+	}
+	return nil
+}
+
+// importChunk writes one chunk of valid rows in a single transaction, storing images as it goes.
+// On failure the stored images are removed again and every row of the chunk is marked failed.
+func (bu *beersleoUsecase) importChunk(ctx context.Context, rows []*beersleo.BeerImportRow, chunk []int, opts *beersleo.BeerImportOptions, report *beersleo.BeerImportReport) {
+	var discard []string
+
+	err := bu.beersleoRepository.Transaction(func(repo beersleoRepositories.IBeersleoRepository) error {
+		for _, i := range chunk {
+			row, result := rows[i], report.Rows[i]
+
+			var before, beer *beersleo.Beersleo
+			if opts.Upsert {
+				matches, err := repo.GetByName(row.Name)
+				if err != nil {
+					return err
+				}
+				if len(matches) > 1 {
+					result.Status = beersleo.ImportFailed
+					result.Errors = ambiguousName(len(matches))
+					continue
+				}
+				if len(matches) == 1 {
+					before = matches[0]
+					current := *before
+					beer = &current
+				}
+			}
+
+			action := audit.ActionBeerUpdate
+			result.Status = beersleo.ImportUpdated
+			if beer == nil {
+				dto := &beersleo.BeerDTO{Name: row.Name, Category: row.Category, CategoryID: &row.CategoryID, Detail: row.Detail}
+				id, err := repo.Create(dto)
+				if err != nil {
+					return err
+				}
+				action = audit.ActionBeerCreate
+				result.Status = beersleo.ImportCreated
+				beer = &beersleo.Beersleo{ID: id, Version: 1}
+			}
+
+			// A beer created without an image is already complete.
+			if before != nil || row.Image != nil {
+				beer.Name, beer.Category, beer.CategoryID, beer.Detail = row.Name, row.Category, &row.CategoryID, row.Detail
+				if row.Image != nil {
+					oldKey := beersleo.ImageKey(beer.Image)
+					stored, err := bu.storeImage(beer.ID, row.Image)
+					// Re-importing a file yields a key the beer or its revisions may already use, which must survive a rollback.
+					if len(stored) > 0 && stored[0] != oldKey {
+						referenced, refErr := referencedByRevision(repo, beer.ID, stored[0])
+						if refErr != nil {
+							return refErr
+						}
+						if !referenced {
+							discard = append(discard, stored...)
+						}
+					}
+					if err != nil {
+						return err
+					}
+					beer.Image = stored[0]
+				}
+				if err := saveRevision(ctx, repo, before, beer); err != nil {
+					return err
+				}
+				if err := repo.Update(beer); err != nil {
+					return err
+				}
+			}
+			result.ID = beer.ID
+
+			after, err := repo.GetByID(beer.ID, false)
+			if err != nil {
+				return err
+			}
+			if err := recordAudit(ctx, repo, action, beer.ID, before, after); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
+		_ = bu.deleteImages(discard)
+
+		appErr := apperrors.As(err)
+		if appErr.Kind == apperrors.KindInternal {
+			logger.From(ctx).Error("import chunk failed", "rows", len(chunk), "err", err)
+		}
+		for _, i := range chunk {
+			result := report.Rows[i]
+			if result.Status == beersleo.ImportFailed && result.Errors != nil {
+				continue
+			}
+			result.Status = beersleo.ImportFailed
+			result.ID = 0
+			result.Errors = map[string]string{"row": "not imported, its chunk was rolled back: " + appErr.Message}
+		}
+		return
+	}
+}
+
+// saveRevision keeps before as a revision of the beer when the update to after changes any of
+// the revisioned fields. A nil before, as for a beer being created, saves nothing.
+func saveRevision(ctx context.Context, repo beersleoRepositories.IBeersleoRepository, before, after *beersleo.Beersleo) error {
+	if before == nil {
+		return nil
+	}
+	rev := beersleo.NewBeerRevision(before, actorID(ctx))
+	if len(beersleo.DiffRevision(rev, after)) == 0 {
+		return nil
+	}
+	return repo.CreateRevision(rev)
+}
+
+// referencedByRevision reports whether a revision of the beer refers to the image stored under key.
+func referencedByRevision(repo beersleoRepositories.IBeersleoRepository, beerID int, key string) (bool, error) {
+	images, err := repo.RevisionImages([]int{beerID})
+	if err != nil {
+		return false, err
+	}
+	for _, image := range images {
+		if beersleo.ImageKey(image) == key {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func actorID(ctx context.Context) string {
+	if principal, ok := auth.PrincipalFromContext(ctx); ok {
+		return principal.ID
+	}
+	return ""
+}
+
+// recordAudit writes an audit entry through repo, so inside Transaction it commits or rolls back
+// with the change.
+func recordAudit(ctx context.Context, repo beersleoRepositories.IBeersleoRepository, action string, id int, before, after *beersleo.Beersleo) error {
+	entry, err := audit.NewEntry(ctx, action, id, before, after)
+	if err != nil {
+		return apperrors.Internal(err, "Failed to record audit entry")
+	}
+	return repo.Audit(entry)
+}
+
+func ambiguousName(matches int) map[string]string {
+	return map[string]string{"name": fmt.Sprintf("matches %d beers, cannot upsert by name", matches)}
+}
+
+// resolveCategory finds the category a beer names by its slug or, failing that, by its name
+// ignoring case and spaces around it. So "IPA", "ipa" and " Ipa " all land in the same category,
+// and one with a custom slug is still found by name. Unknown categories must be created first.
+func (bu *beersleoUsecase) resolveCategory(name string) (*categories.Category, error) {
+	slug := categories.Slugify(name)
+	if slug == "" {
+		return nil, apperrors.Validation("Category is required").With("fields", map[string]string{"category": "required"})
+	}
+
+	category, err := bu.categoriesRepository.GetBySlug(slug)
+	if apperrors.Is(err, apperrors.KindNotFound) {
+		category, err = bu.categoriesRepository.GetByName(name)
+	}
+	if apperrors.Is(err, apperrors.KindNotFound) {
+		return nil, apperrors.Validation("Unknown category %q, create it with POST /v1/categories first", name)
+	}
+	return category, err
+}
+
+// storeImage puts the original under its content hash plus its resized variants and returns
+// every key written, original first, even when it fails part way.
+func (bu *beersleoUsecase) storeImage(id int, image *uploads.Image) ([]string, error) {
+	key := "beers/" + strconv.Itoa(id) + "/" + image.FileName()
+
+	variants, err := uploads.Variants(image)
+	if err != nil {
+		metrics.UploadFailed(metrics.UploadProcessing)
 		return nil, err
 	}
 
-	var beerResponses []*beersleo.BeerDTO
+	if err := bu.imageStore.Put(key, bytes.NewReader(image.Data), image.ContentType); err != nil {
+		metrics.UploadFailed(metrics.UploadStorage)
+		return nil, err
+	}
+	stored := []string{key}
 
-	for _, b := range beersByName {
-		beerResponse := &beersleo.BeerDTO{
-			ID:       b.ID,
-			Name:     b.Name,
-			Category: b.Category,
-			Detail:   b.Detail,
-			Image:    b.Image,
+	for _, variant := range variants {
+		variantKey, _ := uploads.VariantKey(key, variant.Size)
+		if err := bu.imageStore.Put(variantKey, bytes.NewReader(variant.Data), variant.ContentType); err != nil {
+			metrics.UploadFailed(metrics.UploadStorage)
+			return stored, err
 		}
-		beerResponses = append(beerResponses, beerResponse)
+		stored = append(stored, variantKey)
 	}
 
-	return beerResponses, nil
+	metrics.UploadStored(len(image.Data))
+	return stored, nil
+}
+
+func (bu *beersleoUsecase) deleteImages(keys []string) error {
+	for _, key := range keys {
+		if err := bu.imageStore.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,274 @@
+package beersleoUsecases
+
+import (
+	"context"
+	"github.com/peedans/beerleo/modules/audit"
+	"github.com/peedans/beerleo/modules/beersleo"
+	"github.com/peedans/beerleo/modules/beersleo/beersleoRepositories"
+	"github.com/peedans/beerleo/modules/categories"
+	"github.com/peedans/beerleo/modules/categories/categoriesRepositories"
+	"github.com/peedans/beerleo/pkg/apperrors"
+	"github.com/peedans/beerleo/pkg/auth"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// pagingRepository serves a fixed set of rows the way the MySQL repository pages them.
+type pagingRepository struct {
+	beersleoRepositories.IBeersleoRepository
+	beers []*beersleo.Beersleo
+}
+
+func (r *pagingRepository) page(filter *beersleo.BeersleoFilter) ([]*beersleo.Beersleo, int) {
+	limit, offset := filter.Window()
+	total := 0
+	if !filter.SkipCount {
+		total = len(r.beers)
+	}
+	if offset >= len(r.beers) {
+		return nil, total
+	}
+	end := offset + limit
+	if end > len(r.beers) {
+		end = len(r.beers)
+	}
+	return r.beers[offset:end], total
+}
+
+func (r *pagingRepository) GetAllBeersWithPagination(filter *beersleo.BeersleoFilter) ([]*beersleo.Beersleo, int, error) {
+	beers, total := r.page(filter)
+	return beers, total, nil
+}
+
+func (r *pagingRepository) Search(filter *beersleo.BeersleoFilter) ([]*beersleo.BeerSearchResult, int, error) {
+	beers, total := r.page(filter)
+	results := make([]*beersleo.BeerSearchResult, len(beers))
+	for i, beer := range beers {
+		results[i] = &beersleo.BeerSearchResult{Beersleo: *beer}
+	}
+	return results, total, nil
+}
+
+func TestPaginationWithoutCount(t *testing.T) {
+	repo := &pagingRepository{}
+	var want []int
+	for id := 1; id <= 10; id++ {
+		repo.beers = append(repo.beers, &beersleo.Beersleo{ID: id, Name: "lager"})
+		want = append(want, id)
+	}
+	usecase := BeersleoUsecase(repo, nil, nil)
+	ctx := auth.WithPrincipal(context.Background(), &auth.Principal{Kind: auth.PrincipalUser, Permissions: []string{auth.PermBeersRead}})
+
+	tests := []struct {
+		name string
+		list func(filter *beersleo.BeersleoFilter) ([]int, int, error)
+	}{
+		{
+			name: "list",
+			list: func(filter *beersleo.BeersleoFilter) ([]int, int, error) {
+				beers, total, err := usecase.GetAllBeersPagination(ctx, filter)
+				ids := make([]int, len(beers))
+				for i, beer := range beers {
+					ids[i] = beer.ID
+				}
+				return ids, total, err
+			},
+		},
+		{
+			name: "search",
+			list: func(filter *beersleo.BeersleoFilter) ([]int, int, error) {
+				filter.Query = "lager"
+				results, total, err := usecase.SearchBeers(ctx, filter)
+				ids := make([]int, len(results))
+				for i, result := range results {
+					ids[i] = result.ID
+				}
+				return ids, total, err
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, limit := range []int{1, 3, 4, 10, 11} {
+			var got []int
+			for page := 1; ; page++ {
+				filter := &beersleo.BeersleoFilter{Page: page, Limit: limit, SkipCount: true}
+				ids, total, err := tt.list(filter)
+				if err != nil {
+					t.Fatalf("%s limit %d page %d: %v", tt.name, limit, page, err)
+				}
+				if filter.Limit != limit {
+					t.Fatalf("%s limit %d page %d: filter.Limit changed to %d", tt.name, limit, page, filter.Limit)
+				}
+				if len(ids) > limit {
+					t.Fatalf("%s limit %d page %d: got %d rows", tt.name, limit, page, len(ids))
+				}
+				got = append(got, ids...)
+				// The handler offers a next page only while the lower bound passes this page.
+				if total <= page*limit {
+					break
+				}
+				if page > len(want) {
+					t.Fatalf("%s limit %d: paging does not end", tt.name, limit)
+				}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s limit %d: paged ids %v, want %v", tt.name, limit, got, want)
+			}
+		}
+	}
+}
+
+// categoryRepository looks categories up the way the MySQL repository does.
+type categoryRepository struct {
+	categoriesRepositories.ICategoriesRepository
+	list []*categories.Category
+}
+
+func (r *categoryRepository) GetBySlug(slug string) (*categories.Category, error) {
+	for _, category := range r.list {
+		if category.Slug == slug {
+			return category, nil
+		}
+	}
+	return nil, apperrors.NotFound("Category %q not found", slug)
+}
+
+func (r *categoryRepository) GetByName(name string) (*categories.Category, error) {
+	for _, category := range r.list {
+		if strings.EqualFold(category.Name, strings.TrimSpace(name)) {
+			return category, nil
+		}
+	}
+	return nil, apperrors.NotFound("Category %q not found", name)
+}
+
+func TestResolveCategory(t *testing.T) {
+	usecase := &beersleoUsecase{categoriesRepository: &categoryRepository{list: []*categories.Category{
+		{ID: 1, Name: "India Pale Ale", Slug: "ipa"},
+		{ID: 2, Name: "Stout", Slug: "stout"},
+	}}}
+
+	tests := []struct {
+		name    string
+		want    int
+		wantErr apperrors.Kind
+	}{
+		{name: "ipa", want: 1},
+		{name: " IPA ", want: 1},
+		{name: "India Pale Ale", want: 1},
+		{name: " india pale ale ", want: 1},
+		{name: "Stout", want: 2},
+		{name: "india-pale-ale", wantErr: apperrors.KindValidation},
+		{name: "Lager", wantErr: apperrors.KindValidation},
+		{name: " - ", wantErr: apperrors.KindValidation},
+	}
+
+	for _, tt := range tests {
+		category, err := usecase.resolveCategory(tt.name)
+		if tt.wantErr != "" {
+			if !apperrors.Is(err, tt.wantErr) {
+				t.Errorf("resolveCategory(%q) err = %v, want %s", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || category.ID != tt.want {
+			t.Errorf("resolveCategory(%q) = %+v, %v, want category %d", tt.name, category, err, tt.want)
+		}
+	}
+}
+
+// importRepository records the beers and audit entries a transaction writes and drops them when it fails.
+type importRepository struct {
+	beersleoRepositories.IBeersleoRepository
+	failOn         string
+	created        []string
+	pending        []string
+	audited        int
+	pendingAudited int
+}
+
+func (r *importRepository) Transaction(fn func(repo beersleoRepositories.IBeersleoRepository) error) error {
+	r.pending, r.pendingAudited = nil, 0
+	if err := fn(r); err != nil {
+		return err
+	}
+	r.created = append(r.created, r.pending...)
+	r.audited += r.pendingAudited
+	return nil
+}
+
+func (r *importRepository) GetByID(id int, includeDeleted bool) (*beersleo.Beersleo, error) {
+	return &beersleo.Beersleo{ID: id, Version: 1}, nil
+}
+
+func (r *importRepository) Audit(entry *audit.Entry) error {
+	r.pendingAudited++
+	return nil
+}
+
+func (r *importRepository) Create(beer *beersleo.BeerDTO) (int, error) {
+	if beer.Name == r.failOn {
+		return 0, apperrors.Conflict("Beer %q was rejected", beer.Name)
+	}
+	r.pending = append(r.pending, beer.Name)
+	return len(r.created) + len(r.pending), nil
+}
+
+func TestImportBeersRollsBackChunks(t *testing.T) {
+	repo := &importRepository{failOn: "Chang"}
+	usecase := BeersleoUsecase(repo, &categoryRepository{list: []*categories.Category{{ID: 1, Name: "Lager", Slug: "lager"}}}, nil)
+
+	rows := []*beersleo.BeerImportRow{
+		{Row: 1, Name: "Leo", Category: "Lager"},
+		{Row: 2, Name: "Singha", Category: "lager"},
+		{Row: 3, Name: "Chang", Category: "Lager"},
+		{Row: 4, Name: "Archa", Category: "Lager"},
+		{Row: 5, Name: "Tiger", Category: "Stout"},
+		{Row: 6, Name: "Federbrau", Category: "Lager"},
+	}
+	ctx := auth.WithPrincipal(context.Background(), &auth.Principal{Kind: auth.PrincipalUser, Permissions: []string{auth.PermBeersImport}})
+	report, err := usecase.ImportBeers(ctx, rows, &beersleo.BeerImportOptions{ChunkSize: 2})
+	if err != nil {
+		t.Fatalf("ImportBeers: %v", err)
+	}
+
+	tests := []struct {
+		row    int
+		status string
+		error  string
+	}{
+		{row: 1, status: beersleo.ImportCreated},
+		{row: 2, status: beersleo.ImportCreated},
+		{row: 3, status: beersleo.ImportFailed, error: "row"},
+		{row: 4, status: beersleo.ImportFailed, error: "row"},
+		{row: 5, status: beersleo.ImportFailed, error: "category"},
+		{row: 6, status: beersleo.ImportCreated},
+	}
+	for i, tt := range tests {
+		result := report.Rows[i]
+		if result.Row != tt.row || result.Status != tt.status {
+			t.Errorf("row %d: status %q, want %q", result.Row, result.Status, tt.status)
+		}
+		if _, ok := result.Errors[tt.error]; tt.error != "" && !ok {
+			t.Errorf("row %d: errors %v, want one for %q", result.Row, result.Errors, tt.error)
+		}
+		if tt.status == beersleo.ImportFailed && result.ID != 0 {
+			t.Errorf("row %d: failed with ID %d", result.Row, result.ID)
+		}
+	}
+	if !strings.Contains(report.Rows[3].Errors["row"], "rolled back") {
+		t.Errorf("row 4: error %q does not mention the rollback", report.Rows[3].Errors["row"])
+	}
+
+	if report.Created != 3 || report.Failed != 3 || report.Updated != 0 {
+		t.Errorf("report counts created %d, updated %d, failed %d, want 3, 0, 3", report.Created, report.Updated, report.Failed)
+	}
+	if want := []string{"Leo", "Singha", "Federbrau"}; !reflect.DeepEqual(repo.created, want) {
+		t.Errorf("committed %v, want %v", repo.created, want)
+	}
+	if repo.audited != 3 {
+		t.Errorf("committed %d audit entries, want 3", repo.audited)
+	}
+}
@@ -0,0 +1,71 @@
+package categories
+
+import (
+	"strings"
+	"time"
+	"unicode"
+)
+
+type Category struct {
+	ID        int        `db:"id" json:"id"`
+	Name      string     `db:"name" json:"name"`
+	Slug      string     `db:"slug" json:"slug"`
+	ParentID  *int       `db:"parent_id" json:"parent_id"`
+	CreatedAt *time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt *time.Time `db:"updated_at" json:"updated_at"`
+	// Children is only filled in for tree listings and single-category responses.
+	Children []*Category `db:"-" json:"children,omitempty"`
+}
+
+// CategoryRequest creates or fully replaces a category. An empty slug is derived from the name
+// and a nil parent makes it a top-level category.
+type CategoryRequest struct {
+	Name     string `json:"name" form:"name" binding:"required"`
+	Slug     string `json:"slug" form:"slug"`
+	ParentID *int   `json:"parent_id" form:"parent_id"`
+}
+
+// MergeRequest lists the categories folded into the target of a merge.
+type MergeRequest struct {
+	Sources []int `json:"sources" binding:"required,min=1"`
+}
+
+// Slugify lower-cases s and joins its runs of letters and numbers with "-", so "India Pale Ale"
+// and " india pale-ale " both become "india-pale-ale". Thai and other scripts are kept as is.
+// It must stay in line with the slug expression of the categories migration.
+func Slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsMark(r) || unicode.IsNumber(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+	return b.String()
+}
+
+// Tree nests categories under their parents and returns the top-level ones.
+func Tree(list []*Category) []*Category {
+	byID := make(map[int]*Category, len(list))
+	for _, category := range list {
+		byID[category.ID] = category
+	}
+
+	roots := make([]*Category, 0)
+	for _, category := range list {
+		if category.ParentID != nil {
+			if parent, ok := byID[*category.ParentID]; ok {
+				parent.Children = append(parent.Children, category)
+				continue
+			}
+		}
+		roots = append(roots, category)
+	}
+	return roots
+}
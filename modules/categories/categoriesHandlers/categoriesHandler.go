@@ -0,0 +1,151 @@
+package categoriesHandlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/peedans/beerleo/modules/categories"
+	"github.com/peedans/beerleo/modules/categories/categoriesUsecases"
+	"github.com/peedans/beerleo/pkg/apperrors"
+	"net/http"
+	"strconv"
+)
+
+type ICategoriesHandler interface {
+	ListCategories(c *gin.Context)
+	GetCategory(c *gin.Context)
+	CreateCategory(c *gin.Context)
+	UpdateCategory(c *gin.Context)
+	DeleteCategory(c *gin.Context)
+	MergeCategories(c *gin.Context)
+}
+
+type categoriesHandler struct {
+	categoriesUsecase categoriesUsecases.ICategoriesUsecase
+}
+
+func CategoriesHandler(categoriesUsecase categoriesUsecases.ICategoriesUsecase) ICategoriesHandler {
+	return &categoriesHandler{
+		categoriesUsecase: categoriesUsecase,
+	}
+}
+
+// ListCategories returns all categories; tree=true nests subcategories under their parents.
+func (h *categoriesHandler) ListCategories(c *gin.Context) {
+	tree := false
+	if value := c.Query("tree"); value != "" {
+		var err error
+		if tree, err = strconv.ParseBool(value); err != nil {
+			_ = c.Error(apperrors.Validation("Invalid tree value %q", value))
+			return
+		}
+	}
+
+	list, err := h.categoriesUsecase.ListCategories(tree)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": list})
+}
+
+func (h *categoriesHandler) GetCategory(c *gin.Context) {
+	id, err := getCategoryID(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	category, err := h.categoriesUsecase.GetCategory(id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, category)
+}
+
+func (h *categoriesHandler) CreateCategory(c *gin.Context) {
+	var req categories.CategoryRequest
+	if err := c.ShouldBind(&req); err != nil {
+		_ = c.Error(apperrors.Binding(err))
+		return
+	}
+
+	category, err := h.categoriesUsecase.CreateCategory(c.Request.Context(), &req)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, category)
+}
+
+// UpdateCategory is a full replacement: leaving out slug derives it from the name again and
+// leaving out parent_id moves the category to the top level.
+func (h *categoriesHandler) UpdateCategory(c *gin.Context) {
+	id, err := getCategoryID(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	var req categories.CategoryRequest
+	if err := c.ShouldBind(&req); err != nil {
+		_ = c.Error(apperrors.Binding(err))
+		return
+	}
+
+	category, err := h.categoriesUsecase.UpdateCategory(c.Request.Context(), id, &req)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, category)
+}
+
+func (h *categoriesHandler) DeleteCategory(c *gin.Context) {
+	id, err := getCategoryID(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	if err := h.categoriesUsecase.DeleteCategory(c.Request.Context(), id); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Category deleted successfully"})
+}
+
+// MergeCategories folds the categories listed in sources into the category in the path.
+func (h *categoriesHandler) MergeCategories(c *gin.Context) {
+	id, err := getCategoryID(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	var req categories.MergeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		_ = c.Error(apperrors.Binding(err))
+		return
+	}
+
+	category, err := h.categoriesUsecase.MergeCategories(c.Request.Context(), id, req.Sources)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, category)
+}
+
+func getCategoryID(c *gin.Context) (int, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		return 0, apperrors.Validation("Invalid category ID")
+	}
+	return id, nil
+}
@@ -0,0 +1,263 @@
+package categoriesRepositories
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"github.com/peedans/beerleo/modules/audit"
+	"github.com/peedans/beerleo/modules/beersleo/beersleoRepositories"
+	"github.com/peedans/beerleo/modules/categories"
+	"github.com/peedans/beerleo/pkg/apperrors"
+	"github.com/peedans/beerleo/pkg/metrics"
+	"strings"
+)
+
+// repositoryName labels the query duration metrics of this repository.
+const repositoryName = "categories"
+
+type ICategoriesRepository interface {
+	List() ([]*categories.Category, error)
+	GetByID(id int) (*categories.Category, error)
+	GetBySlug(slug string) (*categories.Category, error)
+	GetByName(name string) (*categories.Category, error)
+	Children(id int) ([]*categories.Category, error)
+	CountBeers(id int) (int, error)
+	Create(category *categories.Category) (int, error)
+	Update(category *categories.Category, actor *audit.Actor) error
+	Delete(id int) error
+	Merge(target *categories.Category, sources []int, actor *audit.Actor) error
+}
+
+type categoriesRepository struct {
+	db *sqlx.DB
+}
+
+func CategoriesRepository(db *sqlx.DB) ICategoriesRepository {
+	return &categoriesRepository{
+		db: db,
+	}
+}
+
+// MySQL error numbers for a unique key violation and for deleting a row a foreign key still references.
+const (
+	mysqlDuplicateEntry = 1062
+	mysqlRowReferenced  = 1451
+)
+
+func slugConflict(err error, slug string) error {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDuplicateEntry {
+		return apperrors.Conflict("Category with slug %q already exists", slug)
+	}
+	return err
+}
+
+func (r *categoriesRepository) List() ([]*categories.Category, error) {
+	defer metrics.ObserveQuery(repositoryName, "List")()
+	list := make([]*categories.Category, 0)
+	if err := r.db.Select(&list, "SELECT * FROM categories ORDER BY name, id"); err != nil {
+		return nil, apperrors.Internal(err, "Failed to list categories")
+	}
+	return list, nil
+}
+
+func (r *categoriesRepository) GetByID(id int) (*categories.Category, error) {
+	defer metrics.ObserveQuery(repositoryName, "GetByID")()
+	var category categories.Category
+	err := r.db.Get(&category, "SELECT * FROM categories WHERE id=?", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apperrors.NotFound("Category with ID %d not found", id)
+	}
+	if err != nil {
+		return nil, apperrors.Internal(err, "Failed to retrieve category %d", id)
+	}
+	return &category, nil
+}
+
+func (r *categoriesRepository) GetBySlug(slug string) (*categories.Category, error) {
+	defer metrics.ObserveQuery(repositoryName, "GetBySlug")()
+	var category categories.Category
+	err := r.db.Get(&category, "SELECT * FROM categories WHERE slug=?", slug)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apperrors.NotFound("Category %q not found", slug)
+	}
+	if err != nil {
+		return nil, apperrors.Internal(err, "Failed to retrieve category %q", slug)
+	}
+	return &category, nil
+}
+
+// GetByName finds a category by its name, ignoring case and surrounding spaces. Names are not
+// unique, so the oldest category wins.
+func (r *categoriesRepository) GetByName(name string) (*categories.Category, error) {
+	defer metrics.ObserveQuery(repositoryName, "GetByName")()
+	var category categories.Category
+	err := r.db.Get(&category, "SELECT * FROM categories WHERE LOWER(name)=LOWER(?) ORDER BY id LIMIT 1", strings.TrimSpace(name))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apperrors.NotFound("Category %q not found", name)
+	}
+	if err != nil {
+		return nil, apperrors.Internal(err, "Failed to retrieve category %q", name)
+	}
+	return &category, nil
+}
+
+func (r *categoriesRepository) Children(id int) ([]*categories.Category, error) {
+	defer metrics.ObserveQuery(repositoryName, "Children")()
+	children := make([]*categories.Category, 0)
+	if err := r.db.Select(&children, "SELECT * FROM categories WHERE parent_id=? ORDER BY name, id", id); err != nil {
+		return nil, apperrors.Internal(err, "Failed to list subcategories of %d", id)
+	}
+	return children, nil
+}
+
+// CountBeers counts the beers in the category, soft-deleted ones included since they still
+// reference it.
+func (r *categoriesRepository) CountBeers(id int) (int, error) {
+	defer metrics.ObserveQuery(repositoryName, "CountBeers")()
+	var count int
+	if err := r.db.Get(&count, "SELECT COUNT(*) FROM beers WHERE category_id=?", id); err != nil {
+		return 0, apperrors.Internal(err, "Failed to count beers of category %d", id)
+	}
+	return count, nil
+}
+
+func (r *categoriesRepository) Create(category *categories.Category) (int, error) {
+	defer metrics.ObserveQuery(repositoryName, "Create")()
+	result, err := r.db.NamedExec("INSERT INTO categories(name, slug, parent_id) VALUES (:name, :slug, :parent_id)", category)
+	if err != nil {
+		return 0, slugConflict(err, category.Slug)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(id), nil
+}
+
+// Update saves the category and copies its name onto its beers, whose category column
+// backs full-text search and sorting. Every rewritten beer keeps a revision and an audit
+// entry on behalf of actor.
+func (r *categoriesRepository) Update(category *categories.Category, actor *audit.Actor) error {
+	defer metrics.ObserveQuery(repositoryName, "Update")()
+	return r.transaction(func(tx *sqlx.Tx) error {
+		result, err := tx.NamedExec("UPDATE categories SET name=:name, slug=:slug, parent_id=:parent_id WHERE id=:id", category)
+		if err != nil {
+			return slugConflict(err, category.Slug)
+		}
+		if affected, err := result.RowsAffected(); err != nil {
+			return err
+		} else if affected == 0 {
+			// MySQL reports 0 rows for an unchanged row as well, so only fail when it is gone.
+			if _, err := r.getByID(tx, category.ID); err != nil {
+				return err
+			}
+		}
+
+		var beerIDs []int
+		if err := tx.Select(&beerIDs, "SELECT id FROM beers WHERE category_id=? AND BINARY category <> ? FOR UPDATE", category.ID, category.Name); err != nil {
+			return err
+		}
+		if len(beerIDs) == 0 {
+			return nil
+		}
+		return beersleoRepositories.RecordRewrite(tx, actor, beerIDs, func() error {
+			_, err := tx.Exec("UPDATE beers SET category=?, version=version+1 WHERE category_id=? AND BINARY category <> ?",
+				category.Name, category.ID, category.Name)
+			return err
+		})
+	})
+}
+
+func (r *categoriesRepository) Delete(id int) error {
+	defer metrics.ObserveQuery(repositoryName, "Delete")()
+	result, err := r.db.Exec("DELETE FROM categories WHERE id=?", id)
+	if err != nil {
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlRowReferenced {
+			return apperrors.Conflict("Category with ID %d is still in use", id)
+		}
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return apperrors.NotFound("Category with ID %d not found", id)
+	}
+	return nil
+}
+
+// Merge moves the beers and subcategories of sources to target and deletes the sources,
+// all in one transaction. Every moved beer keeps a revision and an audit entry on behalf of actor.
+func (r *categoriesRepository) Merge(target *categories.Category, sources []int, actor *audit.Actor) error {
+	defer metrics.ObserveQuery(repositoryName, "Merge")()
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(sources)), ",")
+	ids := make([]interface{}, len(sources))
+	for i, id := range sources {
+		ids[i] = id
+	}
+
+	return r.transaction(func(tx *sqlx.Tx) error {
+		var found int
+		if err := tx.Get(&found, "SELECT COUNT(*) FROM categories WHERE id IN ("+placeholders+")", ids...); err != nil {
+			return err
+		}
+		if found != len(sources) {
+			return apperrors.NotFound("One or more source categories were not found")
+		}
+
+		var beerIDs []int
+		if err := tx.Select(&beerIDs, "SELECT id FROM beers WHERE category_id IN ("+placeholders+") FOR UPDATE", ids...); err != nil {
+			return err
+		}
+		if len(beerIDs) > 0 {
+			err := beersleoRepositories.RecordRewrite(tx, actor, beerIDs, func() error {
+				args := append([]interface{}{target.ID, target.Name}, ids...)
+				_, err := tx.Exec("UPDATE beers SET category_id=?, category=?, version=version+1 WHERE category_id IN ("+placeholders+")", args...)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+		args := append([]interface{}{target.ID}, ids...)
+		if _, err := tx.Exec("UPDATE categories SET parent_id=? WHERE parent_id IN ("+placeholders+")", args...); err != nil {
+			return err
+		}
+		_, err := tx.Exec("DELETE FROM categories WHERE id IN ("+placeholders+")", ids...)
+		return err
+	})
+}
+
+func (r *categoriesRepository) getByID(tx *sqlx.Tx, id int) (*categories.Category, error) {
+	var category categories.Category
+	err := tx.Get(&category, "SELECT * FROM categories WHERE id=?", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apperrors.NotFound("Category with ID %d not found", id)
+	}
+	return &category, err
+}
+
+func (r *categoriesRepository) transaction(fn func(tx *sqlx.Tx) error) (err error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,214 @@
+package categoriesUsecases
+
+import (
+	"context"
+	"github.com/peedans/beerleo/modules/audit"
+	"github.com/peedans/beerleo/modules/categories"
+	"github.com/peedans/beerleo/modules/categories/categoriesRepositories"
+	"github.com/peedans/beerleo/pkg/apperrors"
+	"github.com/peedans/beerleo/pkg/auth"
+	"strings"
+)
+
+type ICategoriesUsecase interface {
+	ListCategories(tree bool) ([]*categories.Category, error)
+	GetCategory(id int) (*categories.Category, error)
+	CreateCategory(ctx context.Context, req *categories.CategoryRequest) (*categories.Category, error)
+	UpdateCategory(ctx context.Context, id int, req *categories.CategoryRequest) (*categories.Category, error)
+	DeleteCategory(ctx context.Context, id int) error
+	MergeCategories(ctx context.Context, id int, sources []int) (*categories.Category, error)
+}
+
+type categoriesUsecase struct {
+	categoriesRepository categoriesRepositories.ICategoriesRepository
+}
+
+func CategoriesUsecase(categoriesRepository categoriesRepositories.ICategoriesRepository) ICategoriesUsecase {
+	return &categoriesUsecase{
+		categoriesRepository: categoriesRepository,
+	}
+}
+
+// ListCategories returns every category, ordered by name, or the top-level ones with their
+// subcategories nested when tree is set.
+func (cu *categoriesUsecase) ListCategories(tree bool) ([]*categories.Category, error) {
+	list, err := cu.categoriesRepository.List()
+	if err != nil {
+		return nil, err
+	}
+	if tree {
+		return categories.Tree(list), nil
+	}
+	return list, nil
+}
+
+// GetCategory returns the category with its direct subcategories.
+func (cu *categoriesUsecase) GetCategory(id int) (*categories.Category, error) {
+	category, err := cu.categoriesRepository.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	category.Children, err = cu.categoriesRepository.Children(id)
+	if err != nil {
+		return nil, err
+	}
+	return category, nil
+}
+
+func (cu *categoriesUsecase) CreateCategory(ctx context.Context, req *categories.CategoryRequest) (*categories.Category, error) {
+	if err := auth.Authorize(ctx, auth.PermCategoriesWrite); err != nil {
+		return nil, err
+	}
+	category, err := cu.fromRequest(0, req)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := cu.categoriesRepository.Create(category)
+	if err != nil {
+		return nil, err
+	}
+	return cu.categoriesRepository.GetByID(id)
+}
+
+// UpdateCategory replaces the category's name, slug and parent. Renaming also renames the
+// category on its beers, each keeping a revision and an audit entry for the principal in ctx.
+func (cu *categoriesUsecase) UpdateCategory(ctx context.Context, id int, req *categories.CategoryRequest) (*categories.Category, error) {
+	if err := auth.Authorize(ctx, auth.PermCategoriesWrite); err != nil {
+		return nil, err
+	}
+	if _, err := cu.categoriesRepository.GetByID(id); err != nil {
+		return nil, err
+	}
+	category, err := cu.fromRequest(id, req)
+	if err != nil {
+		return nil, err
+	}
+	category.ID = id
+
+	if err := cu.categoriesRepository.Update(category, audit.ActorFrom(ctx)); err != nil {
+		return nil, err
+	}
+	return cu.categoriesRepository.GetByID(id)
+}
+
+// DeleteCategory removes a category that has neither beers nor subcategories.
+func (cu *categoriesUsecase) DeleteCategory(ctx context.Context, id int) error {
+	if err := auth.Authorize(ctx, auth.PermCategoriesWrite); err != nil {
+		return err
+	}
+	if _, err := cu.categoriesRepository.GetByID(id); err != nil {
+		return err
+	}
+
+	children, err := cu.categoriesRepository.Children(id)
+	if err != nil {
+		return err
+	}
+	if len(children) > 0 {
+		return apperrors.Conflict("Category with ID %d has %d subcategories", id, len(children))
+	}
+	beers, err := cu.categoriesRepository.CountBeers(id)
+	if err != nil {
+		return err
+	}
+	if beers > 0 {
+		return apperrors.Conflict("Category with ID %d still has %d beers, merge it into another category instead", id, beers)
+	}
+
+	return cu.categoriesRepository.Delete(id)
+}
+
+// MergeCategories folds the sources into category id: their beers and subcategories move to it
+// and the sources are deleted. It is how synonyms such as "India Pale Ale" and "IPA" are combined.
+// Moved beers keep a revision and an audit entry, as on rename.
+func (cu *categoriesUsecase) MergeCategories(ctx context.Context, id int, sources []int) (*categories.Category, error) {
+	if err := auth.Authorize(ctx, auth.PermCategoriesWrite); err != nil {
+		return nil, err
+	}
+	target, err := cu.categoriesRepository.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[int]bool, len(sources))
+	unique := make([]int, 0, len(sources))
+	for _, source := range sources {
+		if source == id {
+			return nil, apperrors.Validation("A category cannot be merged into itself")
+		}
+		if !seen[source] {
+			seen[source] = true
+			unique = append(unique, source)
+		}
+	}
+
+	// The target must not sit below a source, or it would lose its place in the tree.
+	ancestors, err := cu.ancestors(target)
+	if err != nil {
+		return nil, err
+	}
+	for _, ancestor := range ancestors {
+		if seen[ancestor] {
+			return nil, apperrors.Validation("Category %d cannot be merged into its own subcategory %d", ancestor, id)
+		}
+	}
+
+	if err := cu.categoriesRepository.Merge(target, unique, audit.ActorFrom(ctx)); err != nil {
+		return nil, err
+	}
+	return cu.GetCategory(id)
+}
+
+// fromRequest validates req for the category id (0 when creating) and builds the category.
+func (cu *categoriesUsecase) fromRequest(id int, req *categories.CategoryRequest) (*categories.Category, error) {
+	name := strings.TrimSpace(req.Name)
+	slug := categories.Slugify(req.Slug)
+	if req.Slug == "" {
+		slug = categories.Slugify(name)
+	}
+	if name == "" || slug == "" {
+		return nil, apperrors.Validation("Category name and slug must contain letters or numbers")
+	}
+
+	category := &categories.Category{Name: name, Slug: slug, ParentID: req.ParentID}
+	if req.ParentID == nil {
+		return category, nil
+	}
+
+	parent, err := cu.categoriesRepository.GetByID(*req.ParentID)
+	if apperrors.Is(err, apperrors.KindNotFound) {
+		return nil, apperrors.Validation("Parent category %d does not exist", *req.ParentID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if id != 0 {
+		ancestors, err := cu.ancestors(parent)
+		if err != nil {
+			return nil, err
+		}
+		for _, ancestor := range append(ancestors, parent.ID) {
+			if ancestor == id {
+				return nil, apperrors.Validation("Category %d cannot be moved below itself", id)
+			}
+		}
+	}
+	return category, nil
+}
+
+// ancestors walks up from category and returns the IDs of its parent, grandparent and so on.
+func (cu *categoriesUsecase) ancestors(category *categories.Category) ([]int, error) {
+	var ids []int
+	seen := map[int]bool{category.ID: true}
+	for category.ParentID != nil && !seen[*category.ParentID] {
+		parent, err := cu.categoriesRepository.GetByID(*category.ParentID)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, parent.ID)
+		seen[parent.ID] = true
+		category = parent
+	}
+	return ids, nil
+}
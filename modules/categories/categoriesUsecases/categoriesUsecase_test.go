@@ -0,0 +1,50 @@
+package categoriesUsecases
+
+import (
+	"context"
+	"github.com/peedans/beerleo/modules/categories"
+	"github.com/peedans/beerleo/pkg/apperrors"
+	"github.com/peedans/beerleo/pkg/auth"
+	"testing"
+)
+
+func TestMutationsRequirePermission(t *testing.T) {
+	usecase := CategoriesUsecase(nil)
+	req := &categories.CategoryRequest{Name: "Lager"}
+
+	mutations := map[string]func(ctx context.Context) error{
+		"create": func(ctx context.Context) error {
+			_, err := usecase.CreateCategory(ctx, req)
+			return err
+		},
+		"update": func(ctx context.Context) error {
+			_, err := usecase.UpdateCategory(ctx, 1, req)
+			return err
+		},
+		"delete": func(ctx context.Context) error {
+			return usecase.DeleteCategory(ctx, 1)
+		},
+		"merge": func(ctx context.Context) error {
+			_, err := usecase.MergeCategories(ctx, 1, []int{2})
+			return err
+		},
+	}
+
+	reader := &auth.Principal{Kind: auth.PrincipalUser, ID: "alice", Permissions: []string{auth.PermBeersRead}}
+	callers := []struct {
+		name string
+		ctx  context.Context
+		want apperrors.Kind
+	}{
+		{name: "anonymous", ctx: context.Background(), want: apperrors.KindUnauthorized},
+		{name: "without categories:write", ctx: auth.WithPrincipal(context.Background(), reader), want: apperrors.KindForbidden},
+	}
+
+	for name, mutate := range mutations {
+		for _, caller := range callers {
+			if err := mutate(caller.ctx); !apperrors.Is(err, caller.want) {
+				t.Errorf("%s by %s: err = %v, want %s", name, caller.name, err, caller.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package categories
+
+import "testing"
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"IPA", "ipa"},
+		{" ipa ", "ipa"},
+		{"Ipa!", "ipa"},
+		{"India Pale Ale", "india-pale-ale"},
+		{" india pale-ale ", "india-pale-ale"},
+		{"Pale  --  Ale", "pale-ale"},
+		{"Stout 2024", "stout-2024"},
+		{"Café Crème", "café-crème"},
+		{"เบียร์ สด", "เบียร์-สด"},
+		{"!!!", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := Slugify(tt.name); got != tt.want {
+			t.Errorf("Slugify(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,377 @@
+package middlewaresHandlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/peedans/beerleo/config"
+	"github.com/peedans/beerleo/modules/middlewares"
+	"github.com/peedans/beerleo/pkg/apperrors"
+	"github.com/peedans/beerleo/pkg/auth"
+	"github.com/peedans/beerleo/pkg/logger"
+	"github.com/peedans/beerleo/pkg/metrics"
+	"github.com/peedans/beerleo/pkg/ratelimit"
+	"github.com/peedans/beerleo/pkg/requestinfo"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"time"
+)
+
+type IMiddlewaresHandler interface {
+	RequestInfo() gin.HandlerFunc
+	ErrorHandler() gin.HandlerFunc
+	Recover() gin.HandlerFunc
+	Metrics() gin.HandlerFunc
+	JwtAuth() gin.HandlerFunc
+	Authenticate() gin.HandlerFunc
+	Identify() gin.HandlerFunc
+	RequirePermission(permission string) gin.HandlerFunc
+	RateLimit(group string) gin.HandlerFunc
+	RateLimitAuth() gin.HandlerFunc
+}
+
+type middlewaresHandler struct {
+	cfg      config.IConfig
+	verifier auth.IVerifier
+	keys     auth.IKeyAuthenticator
+	policy   auth.IPolicy
+	limits   ratelimit.IStore
+	// now is the clock the rate limits run on.
+	now func() time.Time
+}
+
+// MiddlewaresHandler builds the shared middlewares. A nil verifier means no JWT keys are
+// configured, in which case every bearer token is rejected.
+func MiddlewaresHandler(cfg config.IConfig, verifier auth.IVerifier, keys auth.IKeyAuthenticator, policy auth.IPolicy, limits ratelimit.IStore) IMiddlewaresHandler {
+	return &middlewaresHandler{
+		cfg:      cfg,
+		verifier: verifier,
+		keys:     keys,
+		policy:   policy,
+		limits:   limits,
+		now:      time.Now,
+	}
+}
+
+// RequestInfo tags the request with an ID, taken from X-Request-ID when the client sent a usable
+// one, and echoes it back. The ID and client IP are put on the request context for the audit log,
+// along with a logger carrying them, and the request is logged once it is done.
+func (h *middlewaresHandler) RequestInfo() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		id := c.GetHeader(requestinfo.Header)
+		if !requestinfo.ValidID(id) {
+			id = requestinfo.NewID()
+		}
+		c.Header(requestinfo.Header, id)
+
+		info := requestinfo.Info{ID: id, ClientIP: c.ClientIP()}
+		log := slog.Default().With(
+			"request_id", info.ID,
+			"client_ip", info.ClientIP,
+			"method", c.Request.Method,
+			"route", c.FullPath(),
+		)
+		ctx := requestinfo.With(c.Request.Context(), info)
+		c.Request = c.Request.WithContext(logger.With(ctx, log))
+		c.Next()
+
+		// Read the logger back: authentication adds the principal to it.
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case status >= http.StatusBadRequest:
+			level = slog.LevelWarn
+		}
+		logger.From(c.Request.Context()).Log(c.Request.Context(), level, "request",
+			"path", c.Request.URL.Path,
+			"status", status,
+			"duration", time.Since(start),
+			"bytes", c.Writer.Size(),
+		)
+	}
+}
+
+// ErrorHandler renders the last error a handler attached with c.Error as application/problem+json.
+func (h *middlewaresHandler) ErrorHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+
+		appErr := apperrors.As(c.Errors.Last().Err)
+		status := appErr.Status()
+		if status >= http.StatusInternalServerError {
+			logger.From(c.Request.Context()).Error("request failed", "err", appErr)
+		}
+
+		problem := middlewares.Problem{
+			Type:     "about:blank",
+			Title:    http.StatusText(status),
+			Status:   status,
+			Detail:   appErr.Message,
+			Instance: c.Request.URL.RequestURI(),
+		}
+		if appErr.Kind != apperrors.KindInternal {
+			problem.Type = "/problems/" + string(appErr.Kind)
+		}
+
+		writeProblem(c, &problem, appErr.Extensions)
+	}
+}
+
+// Recover turns a panic in a handler into a 500 problem, logged with its stack by ErrorHandler.
+func (h *middlewaresHandler) Recover() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			// The client went away mid-response; net/http expects this panic to reach it.
+			if err, ok := r.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				panic(r)
+			}
+			_ = c.Error(apperrors.Internal(fmt.Errorf("panic: %v\n%s", r, debug.Stack()), "Internal server error"))
+			c.Abort()
+		}()
+		c.Next()
+	}
+}
+
+// Metrics counts requests and times them by method, route template and status.
+func (h *middlewaresHandler) Metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		// Unmatched requests share one series so that probing random paths and methods
+		// cannot blow up the number of series.
+		method, route := c.Request.Method, c.FullPath()
+		if route == "" {
+			method, route = "other", "unmatched"
+		}
+		metrics.ObserveRequest(method, route, c.Writer.Status(), time.Since(start))
+	}
+}
+
+// JwtAuth requires a valid "Authorization: Bearer <jwt>" header and puts the token's claims on
+// the context, where handlers read them with auth.GetClaims. API keys are not accepted.
+func (h *middlewaresHandler) JwtAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if h.jwtAuth(c) {
+			c.Next()
+		}
+	}
+}
+
+// Authenticate accepts either a user JWT or an API key in the X-API-Key header. What the caller
+// may do is left to RequirePermission; an API key's scopes give it the roles of the same name.
+func (h *middlewaresHandler) Authenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if key := c.GetHeader(auth.APIKeyHeader); key != "" {
+			if !h.apiKeyAuth(c, key) {
+				return
+			}
+		} else if !h.jwtAuth(c) {
+			return
+		}
+		c.Next()
+	}
+}
+
+// Identify is Authenticate for public routes: requests without credentials go on as the
+// anonymous principal, but credentials that are sent must be valid.
+func (h *middlewaresHandler) Identify() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if key := c.GetHeader(auth.APIKeyHeader); key != "" {
+			if !h.apiKeyAuth(c, key) {
+				return
+			}
+		} else if c.GetHeader("Authorization") != "" {
+			if !h.jwtAuth(c) {
+				return
+			}
+		} else if !h.setPrincipal(c, auth.AnonymousPrincipal()) {
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequirePermission lets the request through only if the principal set by Identify,
+// Authenticate or JwtAuth holds permission.
+func (h *middlewaresHandler) RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := auth.GetPrincipal(c)
+		if !ok {
+			_ = c.Error(apperrors.Unauthorized("Authentication required"))
+			c.Abort()
+			return
+		}
+		if !principal.Can(permission) {
+			_ = c.Error(apperrors.Forbidden("Permission %q is required", permission))
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RateLimit applies the quota of a rate limit group from the config. Callers are told apart by
+// API key, then user, then client IP, so it goes after Identify, Authenticate or JwtAuth.
+// Every response carries RateLimit-* headers; over the quota the request fails with 429.
+func (h *middlewaresHandler) RateLimit(group string) gin.HandlerFunc {
+	return h.rateLimit(group, func(c *gin.Context) (string, bool) {
+		return rateLimitKey(c), true
+	})
+}
+
+// RateLimitAuth goes before Identify, Authenticate or JwtAuth and limits, per client IP, the
+// requests that present credentials. Requests failing authentication never reach RateLimit,
+// so without it guessing API keys or tokens, each guess costing a bcrypt comparison, would be
+// unlimited. Anonymous requests are left to RateLimit.
+func (h *middlewaresHandler) RateLimitAuth() gin.HandlerFunc {
+	return h.rateLimit(config.RateLimitAuth, func(c *gin.Context) (string, bool) {
+		if c.GetHeader(auth.APIKeyHeader) == "" && c.GetHeader("Authorization") == "" {
+			return "", false
+		}
+		return "ip:" + c.ClientIP(), true
+	})
+}
+
+// rateLimit applies the quota of group to the bucket key names, skipping requests for which it
+// returns false.
+func (h *middlewaresHandler) rateLimit(group string, key func(c *gin.Context) (string, bool)) gin.HandlerFunc {
+	quota, ok := h.cfg.RateLimit().Group(group)
+	if !ok {
+		return func(c *gin.Context) { c.Next() }
+	}
+	limit := ratelimit.Limit{Requests: quota.Requests, Period: quota.Period, Burst: quota.Burst}
+	policy := strconv.Itoa(quota.Requests) + ";w=" + strconv.Itoa(ceilSeconds(quota.Period)) + ";burst=" + strconv.Itoa(quota.Burst)
+
+	return func(c *gin.Context) {
+		bucket, ok := key(c)
+		if !ok {
+			c.Next()
+			return
+		}
+		result, err := h.limits.Take(group+":"+bucket, limit, h.now())
+		if err != nil {
+			// Better to serve without limits than not at all while the store is down.
+			logger.From(c.Request.Context()).Error("rate limit store failed", "err", err)
+			c.Next()
+			return
+		}
+
+		c.Header("RateLimit-Policy", policy)
+		c.Header("RateLimit-Limit", strconv.Itoa(result.Limit))
+		c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+		if !result.Allowed {
+			retryAfter := ceilSeconds(result.RetryAfter)
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			_ = c.Error(apperrors.New(apperrors.KindTooManyRequests, "Rate limit exceeded, retry in %d seconds", retryAfter))
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// rateLimitKey names the bucket of the caller: its API key or user, or its client IP, which
+// gin takes from X-Forwarded-For only when the request came through a trusted proxy.
+func rateLimitKey(c *gin.Context) string {
+	if principal, ok := auth.GetPrincipal(c); ok && principal.ID != "" {
+		switch principal.Kind {
+		case auth.PrincipalAPIKey:
+			return "api_key:" + principal.ID
+		case auth.PrincipalUser:
+			return "user:" + principal.ID
+		}
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// ceilSeconds rounds d up to whole seconds, as the Retry-After and RateLimit-* headers want.
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// jwtAuth authenticates the bearer token, or aborts the request and returns false.
+func (h *middlewaresHandler) jwtAuth(c *gin.Context) bool {
+	token, ok := auth.BearerToken(c)
+	if !ok {
+		c.Header("WWW-Authenticate", `Bearer realm="beerleo"`)
+		_ = c.Error(apperrors.Unauthorized("Missing bearer token or API key"))
+		c.Abort()
+		return false
+	}
+	if h.verifier == nil {
+		_ = c.Error(apperrors.Unauthorized("Token authentication is not configured"))
+		c.Abort()
+		return false
+	}
+
+	claims, err := h.verifier.Verify(token)
+	if err != nil {
+		c.Header("WWW-Authenticate", `Bearer realm="beerleo", error="invalid_token"`)
+		_ = c.Error(apperrors.Wrap(err, apperrors.KindUnauthorized, "Invalid or expired token"))
+		c.Abort()
+		return false
+	}
+
+	auth.SetClaims(c, claims)
+	return h.setPrincipal(c, auth.UserPrincipal(claims))
+}
+
+// apiKeyAuth authenticates key, or aborts the request and returns false.
+func (h *middlewaresHandler) apiKeyAuth(c *gin.Context, key string) bool {
+	principal, err := h.keys.AuthenticateKey(c.Request.Context(), key)
+	if err != nil {
+		_ = c.Error(err)
+		c.Abort()
+		return false
+	}
+
+	return h.setPrincipal(c, principal)
+}
+
+// setPrincipal resolves the principal's permissions and puts it on both the gin context and the
+// request context the usecases check, whose logger then names it too. Otherwise it aborts the
+// request and returns false.
+func (h *middlewaresHandler) setPrincipal(c *gin.Context, principal *auth.Principal) bool {
+	if err := h.policy.Resolve(principal); err != nil {
+		_ = c.Error(err)
+		c.Abort()
+		return false
+	}
+
+	auth.SetPrincipal(c, principal)
+	ctx := auth.WithPrincipal(c.Request.Context(), principal)
+	log := logger.From(ctx).With("actor_kind", principal.Kind, "actor_id", principal.ID)
+	c.Request = c.Request.WithContext(logger.With(ctx, log))
+	return true
+}
+
+// writeProblem merges the extension members into the problem body and writes it.
+func writeProblem(c *gin.Context, problem *middlewares.Problem, extensions map[string]interface{}) {
+	body := make(map[string]interface{}, 5+len(extensions))
+	for key, value := range extensions {
+		body[key] = value
+	}
+
+	raw, _ := json.Marshal(problem)
+	_ = json.Unmarshal(raw, &body)
+
+	c.Header("Content-Type", "application/problem+json")
+	c.AbortWithStatus(problem.Status)
+	_ = json.NewEncoder(c.Writer).Encode(body)
+}
@@ -0,0 +1,128 @@
+package middlewaresHandlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/peedans/beerleo/config"
+	"github.com/peedans/beerleo/pkg/apperrors"
+	"github.com/peedans/beerleo/pkg/ratelimit"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// rateLimitConfig limits the read group and nothing else.
+type rateLimitConfig struct {
+	config.IConfig
+	read config.RateLimit
+}
+
+func (c *rateLimitConfig) RateLimit() config.IRateLimitConfig { return c }
+func (c *rateLimitConfig) Enabled() bool                      { return true }
+func (c *rateLimitConfig) Group(name string) (config.RateLimit, bool) {
+	return c.read, name == config.RateLimitRead
+}
+
+func TestRateLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	type request struct {
+		at         time.Duration
+		allowed    bool
+		remaining  string
+		reset      string
+		retryAfter string
+	}
+	tests := []struct {
+		name     string
+		limit    config.RateLimit
+		requests []request
+	}{
+		{
+			name:  "burst",
+			limit: config.RateLimit{Requests: 60, Period: time.Minute, Burst: 2},
+			requests: []request{
+				{at: 0, allowed: true, remaining: "1", reset: "1"},
+				{at: 0, allowed: true, remaining: "0", reset: "2"},
+				{at: 0, remaining: "0", reset: "2", retryAfter: "1"},
+			},
+		},
+		{
+			name:  "retry after rounds up",
+			limit: config.RateLimit{Requests: 1, Period: 10 * time.Second, Burst: 1},
+			requests: []request{
+				{at: 0, allowed: true, remaining: "0", reset: "10"},
+				{at: 500 * time.Millisecond, remaining: "0", reset: "10", retryAfter: "10"},
+				{at: 9 * time.Second, remaining: "0", reset: "1", retryAfter: "1"},
+				{at: 10 * time.Second, allowed: true, remaining: "0", reset: "10"},
+			},
+		},
+		{
+			name:  "refill",
+			limit: config.RateLimit{Requests: 2, Period: 2 * time.Second, Burst: 2},
+			requests: []request{
+				{at: 0, allowed: true, remaining: "1", reset: "1"},
+				{at: 0, allowed: true, remaining: "0", reset: "2"},
+				{at: time.Second, allowed: true, remaining: "0", reset: "2"},
+				{at: 5 * time.Second, allowed: true, remaining: "1", reset: "1"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var now time.Time
+			h := &middlewaresHandler{
+				cfg:    &rateLimitConfig{read: tt.limit},
+				limits: ratelimit.MemoryStore(),
+				now:    func() time.Time { return now },
+			}
+			served := false
+			r := gin.New()
+			r.GET("/", h.RateLimit(config.RateLimitRead), func(c *gin.Context) { served = true })
+
+			for i, req := range tt.requests {
+				now, served = start.Add(req.at), false
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+				header := w.Header()
+				if served != req.allowed {
+					t.Errorf("request %d at %v: served %v, want %v", i, req.at, served, req.allowed)
+				}
+				if got := header.Get("RateLimit-Remaining"); got != req.remaining {
+					t.Errorf("request %d: RateLimit-Remaining %q, want %q", i, got, req.remaining)
+				}
+				if got := header.Get("RateLimit-Reset"); got != req.reset {
+					t.Errorf("request %d: RateLimit-Reset %q, want %q", i, got, req.reset)
+				}
+				if got := header.Get("Retry-After"); got != req.retryAfter {
+					t.Errorf("request %d: Retry-After %q, want %q", i, got, req.retryAfter)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimitError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := &middlewaresHandler{
+		cfg:    &rateLimitConfig{read: config.RateLimit{Requests: 1, Period: time.Minute, Burst: 1}},
+		limits: ratelimit.MemoryStore(),
+		now:    func() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC) },
+	}
+	limited := h.RateLimit(config.RateLimitRead)
+
+	for i, want := range []bool{false, true} {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		limited(c)
+		if c.IsAborted() != want {
+			t.Fatalf("request %d: aborted %v, want %v", i, c.IsAborted(), want)
+		}
+		if want && !apperrors.Is(c.Errors.Last(), apperrors.KindTooManyRequests) {
+			t.Fatalf("request %d: error %v, want too many requests", i, c.Errors.Last())
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package rbac
+
+type Role struct {
+	ID          int      `db:"id" json:"id"`
+	Name        string   `db:"name" json:"name"`
+	Description string   `db:"description" json:"description"`
+	Permissions []string `db:"-" json:"permissions"`
+}
+
+// UserRolesRequest replaces the roles assigned to a user in the database, which are the only
+// roles the user holds.
+type UserRolesRequest struct {
+	Roles []string `json:"roles" binding:"omitempty,dive,required"`
+}
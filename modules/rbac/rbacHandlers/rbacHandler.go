@@ -0,0 +1,83 @@
+package rbacHandlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/peedans/beerleo/modules/rbac"
+	"github.com/peedans/beerleo/modules/rbac/rbacUsecases"
+	"github.com/peedans/beerleo/pkg/apperrors"
+	"net/http"
+	"strings"
+)
+
+type IRbacHandler interface {
+	ListRoles(c *gin.Context)
+	GetUserRoles(c *gin.Context)
+	SetUserRoles(c *gin.Context)
+}
+
+type rbacHandler struct {
+	rbacUsecase rbacUsecases.IRbacUsecase
+}
+
+func RbacHandler(rbacUsecase rbacUsecases.IRbacUsecase) IRbacHandler {
+	return &rbacHandler{
+		rbacUsecase: rbacUsecase,
+	}
+}
+
+func (h *rbacHandler) ListRoles(c *gin.Context) {
+	roles, err := h.rbacUsecase.ListRoles()
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": roles})
+}
+
+// GetUserRoles lists the roles assigned to a user in the database, by JWT subject.
+func (h *rbacHandler) GetUserRoles(c *gin.Context) {
+	subject, err := getSubject(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	roles, err := h.rbacUsecase.GetUserRoles(subject)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"subject": subject, "roles": roles})
+}
+
+func (h *rbacHandler) SetUserRoles(c *gin.Context) {
+	subject, err := getSubject(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	var req rbac.UserRolesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		_ = c.Error(apperrors.Binding(err))
+		return
+	}
+
+	roles, err := h.rbacUsecase.SetUserRoles(subject, req.Roles)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"subject": subject, "roles": roles})
+}
+
+func getSubject(c *gin.Context) (string, error) {
+	subject := strings.TrimSpace(c.Param("subject"))
+	if subject == "" || len(subject) > 255 {
+		return "", apperrors.Validation("Invalid user subject")
+	}
+	return subject, nil
+}
@@ -0,0 +1,107 @@
+package rbacRepositories
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/peedans/beerleo/modules/rbac"
+	"github.com/peedans/beerleo/pkg/apperrors"
+	"github.com/peedans/beerleo/pkg/metrics"
+	"sort"
+)
+
+// repositoryName labels the query duration metrics of this repository.
+const repositoryName = "rbac"
+
+type IRbacRepository interface {
+	ListRoles() ([]*rbac.Role, error)
+	UserRoles(subject string) ([]string, error)
+	SetUserRoles(subject string, roles []string) error
+}
+
+type rbacRepository struct {
+	db *sqlx.DB
+}
+
+func RbacRepository(db *sqlx.DB) IRbacRepository {
+	return &rbacRepository{
+		db: db,
+	}
+}
+
+// ListRoles returns every role with the names of its permissions.
+func (r *rbacRepository) ListRoles() ([]*rbac.Role, error) {
+	defer metrics.ObserveQuery(repositoryName, "ListRoles")()
+	roles := make([]*rbac.Role, 0)
+	if err := r.db.Select(&roles, "SELECT id, name, description FROM roles ORDER BY name"); err != nil {
+		return nil, apperrors.Internal(err, "Failed to list roles")
+	}
+
+	var grants []struct {
+		RoleID     int    `db:"role_id"`
+		Permission string `db:"permission"`
+	}
+	err := r.db.Select(&grants, `SELECT rp.role_id, p.name AS permission
+		FROM role_permissions rp JOIN permissions p ON p.id = rp.permission_id`)
+	if err != nil {
+		return nil, apperrors.Internal(err, "Failed to list role permissions")
+	}
+
+	byID := make(map[int]*rbac.Role, len(roles))
+	for _, role := range roles {
+		role.Permissions = make([]string, 0)
+		byID[role.ID] = role
+	}
+	for _, grant := range grants {
+		if role, ok := byID[grant.RoleID]; ok {
+			role.Permissions = append(role.Permissions, grant.Permission)
+		}
+	}
+	for _, role := range roles {
+		sort.Strings(role.Permissions)
+	}
+	return roles, nil
+}
+
+func (r *rbacRepository) UserRoles(subject string) ([]string, error) {
+	defer metrics.ObserveQuery(repositoryName, "UserRoles")()
+	roles := make([]string, 0)
+	err := r.db.Select(&roles, `SELECT r.name FROM user_roles ur JOIN roles r ON r.id = ur.role_id
+		WHERE ur.subject=? ORDER BY r.name`, subject)
+	if err != nil {
+		return nil, apperrors.Internal(err, "Failed to retrieve roles of user %q", subject)
+	}
+	return roles, nil
+}
+
+// SetUserRoles replaces the roles of the user in one transaction. Every role must exist.
+func (r *rbacRepository) SetUserRoles(subject string, roles []string) (err error) {
+	defer metrics.ObserveQuery(repositoryName, "SetUserRoles")()
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.Exec("DELETE FROM user_roles WHERE subject=?", subject); err != nil {
+		return err
+	}
+	if len(roles) > 0 {
+		query, args, err := sqlx.In("INSERT INTO user_roles (subject, role_id) SELECT ?, id FROM roles WHERE name IN (?)", subject, roles)
+		if err != nil {
+			return err
+		}
+		result, err := tx.Exec(query, args...)
+		if err != nil {
+			return err
+		}
+		if affected, err := result.RowsAffected(); err != nil {
+			return err
+		} else if int(affected) != len(roles) {
+			return apperrors.Validation("One or more roles do not exist")
+		}
+	}
+	return tx.Commit()
+}
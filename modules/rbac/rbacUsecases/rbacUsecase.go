@@ -0,0 +1,125 @@
+package rbacUsecases
+
+import (
+	"github.com/peedans/beerleo/modules/rbac"
+	"github.com/peedans/beerleo/modules/rbac/rbacRepositories"
+	"github.com/peedans/beerleo/pkg/auth"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// roleCacheTTL is how long role permissions are reused before they are read again, so changes
+// made in the database apply within this time.
+const roleCacheTTL = 30 * time.Second
+
+type IRbacUsecase interface {
+	auth.IPolicy
+	ListRoles() ([]*rbac.Role, error)
+	GetUserRoles(subject string) ([]string, error)
+	SetUserRoles(subject string, roles []string) ([]string, error)
+}
+
+type rbacUsecase struct {
+	rbacRepository rbacRepositories.IRbacRepository
+
+	mu        sync.Mutex
+	roles     map[string][]string // role name -> permissions
+	rolesRead time.Time
+}
+
+func RbacUsecase(rbacRepository rbacRepositories.IRbacRepository) IRbacUsecase {
+	return &rbacUsecase{
+		rbacRepository: rbacRepository,
+	}
+}
+
+// Resolve fills in the roles and permissions of principal. Users hold the roles assigned to them
+// in the database, never the roles claim of their token; API keys hold the roles named after
+// their scopes, and anonymous callers the anonymous role. Whatever anonymous callers may do, authenticated ones
+// may do too, so users and API keys also get the anonymous role's permissions.
+func (u *rbacUsecase) Resolve(principal *auth.Principal) error {
+	var roles []string
+	switch principal.Kind {
+	case auth.PrincipalSystem:
+		return nil
+	case auth.PrincipalUser:
+		assigned, err := u.rbacRepository.UserRoles(principal.ID)
+		if err != nil {
+			return err
+		}
+		roles = assigned
+	case auth.PrincipalAPIKey:
+		roles = principal.Scopes
+	default:
+		roles = []string{auth.PrincipalAnonymous}
+	}
+
+	rolePermissions, err := u.rolePermissions()
+	if err != nil {
+		return err
+	}
+
+	principal.Roles = unique(roles)
+	permissions := append([]string(nil), rolePermissions[auth.PrincipalAnonymous]...)
+	for _, role := range principal.Roles {
+		permissions = append(permissions, rolePermissions[role]...)
+	}
+	principal.Permissions = unique(permissions)
+	return nil
+}
+
+func (u *rbacUsecase) ListRoles() ([]*rbac.Role, error) {
+	return u.rbacRepository.ListRoles()
+}
+
+func (u *rbacUsecase) GetUserRoles(subject string) ([]string, error) {
+	return u.rbacRepository.UserRoles(subject)
+}
+
+func (u *rbacUsecase) SetUserRoles(subject string, roles []string) ([]string, error) {
+	for i, role := range roles {
+		roles[i] = strings.TrimSpace(role)
+	}
+	if err := u.rbacRepository.SetUserRoles(subject, unique(roles)); err != nil {
+		return nil, err
+	}
+	return u.rbacRepository.UserRoles(subject)
+}
+
+// rolePermissions returns the permissions of every role, read from the database at most once
+// per roleCacheTTL.
+func (u *rbacUsecase) rolePermissions() (map[string][]string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.roles != nil && time.Since(u.rolesRead) < roleCacheTTL {
+		return u.roles, nil
+	}
+
+	list, err := u.rbacRepository.ListRoles()
+	if err != nil {
+		return nil, err
+	}
+	roles := make(map[string][]string, len(list))
+	for _, role := range list {
+		roles[role.Name] = role.Permissions
+	}
+	u.roles, u.rolesRead = roles, time.Now()
+	return roles, nil
+}
+
+// unique sorts values and drops duplicates and empty strings.
+func unique(values []string) []string {
+	out := make([]string, 0, len(values))
+	seen := make(map[string]bool, len(values))
+	for _, value := range values {
+		if value != "" && !seen[value] {
+			seen[value] = true
+			out = append(out, value)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
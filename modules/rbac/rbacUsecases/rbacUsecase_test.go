@@ -0,0 +1,87 @@
+package rbacUsecases
+
+import (
+	"github.com/peedans/beerleo/modules/rbac"
+	"github.com/peedans/beerleo/modules/rbac/rbacRepositories"
+	"github.com/peedans/beerleo/pkg/auth"
+	"reflect"
+	"testing"
+)
+
+type rbacRepository struct {
+	rbacRepositories.IRbacRepository
+	roles     []*rbac.Role
+	userRoles map[string][]string
+}
+
+func (r *rbacRepository) ListRoles() ([]*rbac.Role, error) {
+	return r.roles, nil
+}
+
+func (r *rbacRepository) UserRoles(subject string) ([]string, error) {
+	return r.userRoles[subject], nil
+}
+
+func TestResolve(t *testing.T) {
+	usecase := RbacUsecase(&rbacRepository{
+		roles: []*rbac.Role{
+			{Name: auth.PrincipalAnonymous, Permissions: []string{auth.PermBeersRead}},
+			{Name: "editor", Permissions: []string{auth.PermBeersCreate, auth.PermBeersUpdate}},
+			{Name: "admin", Permissions: []string{auth.PermBeersRestore, auth.PermRolesManage}},
+			{Name: auth.ScopeBeersWrite, Permissions: []string{auth.PermBeersCreate}},
+		},
+		userRoles: map[string][]string{"alice": {"editor"}},
+	})
+
+	tests := []struct {
+		name            string
+		principal       *auth.Principal
+		wantRoles       []string
+		wantPermissions []string
+	}{
+		{
+			name:            "user with database roles",
+			principal:       &auth.Principal{Kind: auth.PrincipalUser, ID: "alice"},
+			wantRoles:       []string{"editor"},
+			wantPermissions: []string{auth.PermBeersCreate, auth.PermBeersRead, auth.PermBeersUpdate},
+		},
+		{
+			name:            "roles claimed by the token grant nothing",
+			principal:       &auth.Principal{Kind: auth.PrincipalUser, ID: "alice", Roles: []string{"admin"}},
+			wantRoles:       []string{"editor"},
+			wantPermissions: []string{auth.PermBeersCreate, auth.PermBeersRead, auth.PermBeersUpdate},
+		},
+		{
+			name:            "user without database roles",
+			principal:       &auth.Principal{Kind: auth.PrincipalUser, ID: "bob", Roles: []string{"admin"}},
+			wantRoles:       []string{},
+			wantPermissions: []string{auth.PermBeersRead},
+		},
+		{
+			name:            "api key",
+			principal:       &auth.Principal{Kind: auth.PrincipalAPIKey, ID: "1", Scopes: []string{auth.ScopeBeersWrite}},
+			wantRoles:       []string{auth.ScopeBeersWrite},
+			wantPermissions: []string{auth.PermBeersCreate, auth.PermBeersRead},
+		},
+		{
+			name:            "anonymous",
+			principal:       auth.AnonymousPrincipal(),
+			wantRoles:       []string{auth.PrincipalAnonymous},
+			wantPermissions: []string{auth.PermBeersRead},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := usecase.Resolve(tt.principal); err != nil {
+				t.Fatalf("Resolve: %v", err)
+			}
+			if !reflect.DeepEqual(tt.principal.Roles, tt.wantRoles) {
+				t.Errorf("roles %v, want %v", tt.principal.Roles, tt.wantRoles)
+			}
+			if !reflect.DeepEqual(tt.principal.Permissions, tt.wantPermissions) {
+				t.Errorf("permissions %v, want %v", tt.principal.Permissions, tt.wantPermissions)
+			}
+		})
+	}
+}
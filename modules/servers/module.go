@@ -1,30 +1,54 @@
 package servers
 
 import (
+	"context"
 	"github.com/gin-gonic/gin"
+	"github.com/peedans/beerleo/config"
+	"github.com/peedans/beerleo/modules/apikeys/apikeysHandlers"
+	"github.com/peedans/beerleo/modules/audit/auditHandlers"
+	"github.com/peedans/beerleo/modules/audit/auditRepositories"
+	"github.com/peedans/beerleo/modules/audit/auditUsecases"
 	"github.com/peedans/beerleo/modules/beersleo/beersleoHandlers"
 	"github.com/peedans/beerleo/modules/beersleo/beersleoRepositories"
 	"github.com/peedans/beerleo/modules/beersleo/beersleoUsecases"
+	"github.com/peedans/beerleo/modules/categories/categoriesHandlers"
+	"github.com/peedans/beerleo/modules/categories/categoriesRepositories"
+	"github.com/peedans/beerleo/modules/categories/categoriesUsecases"
+	"github.com/peedans/beerleo/modules/middlewares/middlewaresHandlers"
 	monitorHandlers "github.com/peedans/beerleo/modules/monitorHandlers/handlers"
+	"github.com/peedans/beerleo/modules/rbac/rbacHandlers"
+	"github.com/peedans/beerleo/pkg/auth"
+	"github.com/peedans/beerleo/pkg/logger"
+	"log/slog"
 )
 
 type IModuleFactory interface {
 	monitorModule()
 	beersleoModule()
+	categoriesModule()
+	apikeysModule()
+	rbacModule()
+	auditModule()
 }
 
 type moduleFactory struct {
-	r *gin.RouterGroup
-	s *server
+	r   *gin.RouterGroup
+	s   *server
+	mid middlewaresHandlers.IMiddlewaresHandler
 }
 
-func InitModule(r *gin.RouterGroup, s *server) IModuleFactory {
+func InitModule(r *gin.RouterGroup, s *server, mid middlewaresHandlers.IMiddlewaresHandler) IModuleFactory {
 	return &moduleFactory{
-		r: r,
-		s: s,
+		r:   r,
+		s:   s,
+		mid: mid,
 	}
 }
 
+func InitMiddlewares(s *server) middlewaresHandlers.IMiddlewaresHandler {
+	return middlewaresHandlers.MiddlewaresHandler(s.cfg, s.verifier, s.apiKeys, s.rbac, s.limits)
+}
+
 func (mf *moduleFactory) monitorModule() {
 	handler := monitorHandlers.MonitorHandler(mf.s.cfg)
 	mf.r.GET("/", handler.HealthCheck)
@@ -32,13 +56,93 @@ func (mf *moduleFactory) monitorModule() {
 
 func (mf *moduleFactory) beersleoModule() {
 	repo := beersleoRepositories.BeersleoRepository(mf.s.db)
-	usecases := beersleoUsecases.BeersleoUsecase(repo)
-	handler := beersleoHandlers.BeersleoHandler(usecases)
+	categoriesRepo := categoriesRepositories.CategoriesRepository(mf.s.db)
+	usecases := beersleoUsecases.BeersleoUsecase(repo, categoriesRepo, mf.s.imageStore)
+	handler := beersleoHandlers.BeersleoHandler(mf.s.cfg.Beer(), usecases, mf.s.imageStore)
+
+	beerCfg := mf.s.cfg.Beer()
+	mf.s.runEvery(beerCfg.PurgeInterval(), func() {
+		log := slog.Default().With("job", "purge_deleted_beers")
+		ctx := logger.With(auth.WithPrincipal(context.Background(), auth.SystemPrincipal), log)
+		purged, err := usecases.PurgeDeletedBeers(ctx, beerCfg.PurgeRetention())
+		if err != nil {
+			log.Error("purge deleted beers failed", "err", err)
+			return
+		}
+		if purged > 0 {
+			log.Info("purged deleted beers", "count", purged)
+		}
+	})
+
+	// Reads are open to anonymous callers; writes need a user token or an API key. What each
+	// caller may do is decided by the permissions of its roles; an API key holds the roles named
+	// after its scopes.
 	beerRouter := mf.r.Group("/beers")
-	beerRouter.GET("/filter", handler.FilterBeersByName)
-	beerRouter.GET("/", handler.GetAllBeersPagination)
-	beerRouter.GET("/:id", handler.GetBeerByID)
-	beerRouter.DELETE("/:id", handler.DeleteBeer)
-	beerRouter.POST("/", handler.CreateBeer)
-	beerRouter.PUT("/:id", handler.UpdateBeer)
+	beerReader := beerRouter.Group("", mf.mid.RateLimitAuth(), mf.mid.Identify(), mf.mid.RateLimit(config.RateLimitRead), mf.mid.RequirePermission(auth.PermBeersRead))
+	beerReader.GET("/filter", handler.GetAllBeersPagination)
+	beerReader.GET("/search", handler.SearchBeers)
+	beerReader.GET("/export", handler.ExportBeers)
+	beerReader.GET("/", handler.GetAllBeersPagination)
+	beerReader.GET("/:id", handler.GetBeerByID)
+	beerReader.GET("/:id/image", handler.GetBeerImage)
+	beerReader.HEAD("/:id/image", handler.GetBeerImage)
+	beerReader.GET("/:id/revisions", handler.ListBeerRevisions)
+	beerReader.GET("/:id/revisions/:rev", handler.GetBeerRevision)
+	beerReader.GET("/:id/revisions/:rev/image", handler.GetBeerRevisionImage)
+	beerReader.HEAD("/:id/revisions/:rev/image", handler.GetBeerRevisionImage)
+
+	beerWriter := beerRouter.Group("", mf.mid.RateLimitAuth(), mf.mid.Authenticate(), mf.mid.RateLimit(config.RateLimitWrite))
+	beerWriter.DELETE("/:id", mf.mid.RequirePermission(auth.PermBeersDelete), handler.DeleteBeer)
+	beerWriter.POST("/:id/restore", mf.mid.RequirePermission(auth.PermBeersRestore), handler.RestoreBeer)
+	beerWriter.POST("/", mf.mid.RequirePermission(auth.PermBeersCreate), handler.CreateBeer)
+	beerWriter.POST("/import", mf.mid.RequirePermission(auth.PermBeersImport), handler.ImportBeers)
+	beerWriter.PUT("/:id", mf.mid.RequirePermission(auth.PermBeersUpdate), handler.UpdateBeer)
+	beerWriter.PATCH("/:id", mf.mid.RequirePermission(auth.PermBeersUpdate), handler.PatchBeer)
+	beerWriter.POST("/:id/revisions/:rev/revert", mf.mid.RequirePermission(auth.PermBeersUpdate), handler.RevertBeer)
+}
+
+func (mf *moduleFactory) categoriesModule() {
+	repo := categoriesRepositories.CategoriesRepository(mf.s.db)
+	usecases := categoriesUsecases.CategoriesUsecase(repo)
+	handler := categoriesHandlers.CategoriesHandler(usecases)
+
+	categoryRouter := mf.r.Group("/categories")
+	categoryReader := categoryRouter.Group("", mf.mid.RateLimit(config.RateLimitRead))
+	categoryReader.GET("/", handler.ListCategories)
+	categoryReader.GET("/:id", handler.GetCategory)
+
+	categoryWriter := categoryRouter.Group("", mf.mid.RateLimitAuth(), mf.mid.Authenticate(), mf.mid.RateLimit(config.RateLimitWrite), mf.mid.RequirePermission(auth.PermCategoriesWrite))
+	categoryWriter.POST("/", handler.CreateCategory)
+	categoryWriter.PUT("/:id", handler.UpdateCategory)
+	categoryWriter.DELETE("/:id", handler.DeleteCategory)
+	categoryWriter.POST("/:id/merge", handler.MergeCategories)
+}
+
+// apikeysModule serves key management, which is reserved for users signed in with a JWT.
+func (mf *moduleFactory) apikeysModule() {
+	handler := apikeysHandlers.ApiKeysHandler(mf.s.apiKeys)
+
+	apiKeyRouter := mf.r.Group("/api-keys", mf.mid.RateLimitAuth(), mf.mid.JwtAuth(), mf.mid.RateLimit(config.RateLimitAdmin), mf.mid.RequirePermission(auth.PermApiKeysManage))
+	apiKeyRouter.GET("/", handler.ListApiKeys)
+	apiKeyRouter.GET("/:id", handler.GetApiKey)
+	apiKeyRouter.POST("/", handler.CreateApiKey)
+	apiKeyRouter.POST("/:id/rotate", handler.RotateApiKey)
+	apiKeyRouter.DELETE("/:id", handler.RevokeApiKey)
+}
+
+func (mf *moduleFactory) rbacModule() {
+	handler := rbacHandlers.RbacHandler(mf.s.rbac)
+
+	rbacRouter := mf.r.Group("", mf.mid.RateLimitAuth(), mf.mid.JwtAuth(), mf.mid.RateLimit(config.RateLimitAdmin), mf.mid.RequirePermission(auth.PermRolesManage))
+	rbacRouter.GET("/roles", handler.ListRoles)
+	rbacRouter.GET("/users/:subject/roles", handler.GetUserRoles)
+	rbacRouter.PUT("/users/:subject/roles", handler.SetUserRoles)
+}
+
+func (mf *moduleFactory) auditModule() {
+	repo := auditRepositories.AuditRepository(mf.s.db)
+	usecases := auditUsecases.AuditUsecase(repo)
+	handler := auditHandlers.AuditHandler(usecases)
+
+	mf.r.GET("/audit", mf.mid.RateLimitAuth(), mf.mid.JwtAuth(), mf.mid.RateLimit(config.RateLimitAdmin), mf.mid.RequirePermission(auth.PermAuditRead), handler.ListEntries)
 }
@@ -6,7 +6,16 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/jmoiron/sqlx"
 	"github.com/peedans/beerleo/config"
-	"log"
+	"github.com/peedans/beerleo/modules/apikeys/apikeysRepositories"
+	"github.com/peedans/beerleo/modules/apikeys/apikeysUsecases"
+	"github.com/peedans/beerleo/modules/rbac/rbacRepositories"
+	"github.com/peedans/beerleo/modules/rbac/rbacUsecases"
+	"github.com/peedans/beerleo/pkg/auth"
+	"github.com/peedans/beerleo/pkg/logger"
+	"github.com/peedans/beerleo/pkg/metrics"
+	"github.com/peedans/beerleo/pkg/ratelimit"
+	"github.com/peedans/beerleo/pkg/storages"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -21,26 +30,85 @@ type server struct {
 	app *gin.Engine
 	cfg config.IConfig
 	db  *sqlx.DB
+
+	imageStore storages.IImageStore
+	verifier   auth.IVerifier
+	// apiKeys is shared by the API key middleware and the management endpoints, so rotating or
+	// revoking a key also drops it from the middleware's cache.
+	apiKeys apikeysUsecases.IApiKeysUsecase
+	// rbac resolves the permissions of every principal and serves role management.
+	rbac rbacUsecases.IRbacUsecase
+	// limits holds the rate limit buckets of every route group.
+	limits ratelimit.IStore
+
+	// jobs is cancelled on shutdown to stop background jobs started with runEvery.
+	jobs     context.Context
+	stopJobs context.CancelFunc
 }
 
 func NewServer(cfg config.IConfig, db *sqlx.DB) IServer {
 	gin.SetMode(gin.ReleaseMode)
 
-	app := gin.Default()
+	// gin's own logger and recovery are replaced by the RequestInfo and Recover middlewares.
+	app := gin.New()
+	// Without trusted proxies gin would believe any X-Forwarded-For, letting clients pick the IP
+	// they are rate limited and audited by.
+	if err := app.SetTrustedProxies(cfg.App().TrustedProxies()); err != nil {
+		logger.Fatal("invalid APP_TRUSTED_PROXIES", "err", err)
+	}
+
+	imageStore, err := storages.ImageStore(cfg.Storage())
+	if err != nil {
+		logger.Fatal("init image storage failed", "err", err)
+	}
+
+	verifier, err := auth.Verifier(cfg.Jwt())
+	if errors.Is(err, auth.ErrNoKeys) {
+		slog.Warn("no JWT keys configured (JWT_HMAC_SECRET, JWT_RSA_PUBLIC_KEY_FILE, JWT_JWKS_FILE), protected routes will reject every request")
+	} else if err != nil {
+		logger.Fatal("init jwt verifier failed", "err", err)
+	}
+
+	if cfg.Metrics().Enabled() {
+		metrics.RegisterDB("beerleo", db)
+	}
+
+	jobs, stopJobs := context.WithCancel(context.Background())
 	return &server{
-		cfg: cfg,
-		db:  db,
-		app: app,
+		cfg:        cfg,
+		db:         db,
+		app:        app,
+		imageStore: imageStore,
+		verifier:   verifier,
+		apiKeys:    apikeysUsecases.ApiKeysUsecase(apikeysRepositories.ApiKeysRepository(db)),
+		rbac:       rbacUsecases.RbacUsecase(rbacRepositories.RbacRepository(db)),
+		limits:     ratelimit.MemoryStore(),
+		jobs:       jobs,
+		stopJobs:   stopJobs,
 	}
 }
 
 func (s *server) Start() {
 
+	middlewares := InitMiddlewares(s)
+	s.app.Use(middlewares.RequestInfo())
+	if s.cfg.Metrics().Enabled() {
+		s.app.Use(middlewares.Metrics())
+	}
+	s.app.Use(middlewares.ErrorHandler(), middlewares.Recover())
+
 	v1 := s.app.Group("v1")
-	modules := InitModule(v1, s)
+	modules := InitModule(v1, s, middlewares)
 
 	modules.monitorModule()
 	modules.beersleoModule()
+	modules.categoriesModule()
+	modules.apikeysModule()
+	modules.rbacModule()
+	modules.auditModule()
+
+	metricsSrv := s.serveMetrics()
+
 	// Graceful Shutdown
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
@@ -51,24 +119,71 @@ func (s *server) Start() {
 	go func() {
 		<-c // Wait for an interrupt signal.
 
-		log.Println("Received interrupt. Shutting down servers...")
+		slog.Info("received interrupt, shutting down server")
+		s.stopJobs()
 
 		// Create a context with a 5-second timeout to allow for graceful shutdown.
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
 		// Attempt to gracefully shutdown the servers.
+		if metricsSrv != nil {
+			_ = metricsSrv.Shutdown(ctx)
+		}
 		if err := srv.Shutdown(ctx); err != nil {
-			log.Fatalf("Server shutdown failed: %v", err)
+			logger.Fatal("server shutdown failed", "err", err)
 		} else {
-			log.Println("Server shutdown successfully.")
+			slog.Info("server shut down")
 		}
 	}()
 
 	// Listen to host:port
-	log.Printf("servers is starting on %v", s.cfg.App().Url())
+	slog.Info("server is starting", "addr", s.cfg.App().Url(), "version", s.cfg.App().Version())
 	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-		log.Fatalf("listen: %s\n", err)
+		logger.Fatal("listen failed", "err", err)
 	}
 
 }
+
+// serveMetrics exposes the Prometheus metrics on their own listener, kept off the public API, and
+// returns its server, or nil when metrics are disabled.
+func (s *server) serveMetrics() *http.Server {
+	metricsCfg := s.cfg.Metrics()
+	if !metricsCfg.Enabled() {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(metricsCfg.Path(), metrics.Handler())
+	srv := &http.Server{
+		Addr:              metricsCfg.Addr(),
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		slog.Info("metrics server is starting", "addr", metricsCfg.Addr(), "path", metricsCfg.Path())
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Fatal("metrics listen failed", "err", err)
+		}
+	}()
+	return srv
+}
+
+// runEvery runs job in the background on every tick of interval until the server shuts down.
+func (s *server) runEvery(interval time.Duration, job func()) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.jobs.Done():
+				return
+			case <-ticker.C:
+				job()
+			}
+		}
+	}()
+}
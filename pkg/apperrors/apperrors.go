@@ -0,0 +1,144 @@
+package apperrors
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"net/http"
+	"strings"
+)
+
+type Kind string
+
+const (
+	KindValidation       Kind = "validation"
+	KindUnauthorized     Kind = "unauthorized"
+	KindForbidden        Kind = "forbidden"
+	KindNotFound         Kind = "not-found"
+	KindConflict         Kind = "conflict"
+	KindPrecondition     Kind = "precondition-failed"
+	KindTooLarge         Kind = "too-large"
+	KindUnsupportedMedia Kind = "unsupported-media-type"
+	KindTooManyRequests  Kind = "too-many-requests"
+	KindInternal         Kind = "internal"
+)
+
+var statusByKind = map[Kind]int{
+	KindValidation:       http.StatusBadRequest,
+	KindUnauthorized:     http.StatusUnauthorized,
+	KindForbidden:        http.StatusForbidden,
+	KindNotFound:         http.StatusNotFound,
+	KindConflict:         http.StatusConflict,
+	KindPrecondition:     http.StatusPreconditionFailed,
+	KindTooLarge:         http.StatusRequestEntityTooLarge,
+	KindUnsupportedMedia: http.StatusUnsupportedMediaType,
+	KindTooManyRequests:  http.StatusTooManyRequests,
+	KindInternal:         http.StatusInternalServerError,
+}
+
+// Error is a domain error carrying the kind used to pick the HTTP status and an optional cause.
+type Error struct {
+	Kind    Kind
+	Message string
+	// Extensions are extra members added to the problem+json body, e.g. per-field validation errors.
+	Extensions map[string]interface{}
+	Err        error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Status is the HTTP status code for the error's kind.
+func (e *Error) Status() int {
+	if status, ok := statusByKind[e.Kind]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// With adds a problem+json extension member and returns e for chaining.
+func (e *Error) With(key string, value interface{}) *Error {
+	if e.Extensions == nil {
+		e.Extensions = make(map[string]interface{})
+	}
+	e.Extensions[key] = value
+	return e
+}
+
+func New(kind Kind, format string, args ...interface{}) *Error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, args...)}
+}
+
+// Wrap attaches kind and message to err, keeping err reachable through errors.Is/As.
+func Wrap(err error, kind Kind, format string, args ...interface{}) *Error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, args...), Err: err}
+}
+
+func Validation(format string, args ...interface{}) *Error {
+	return New(KindValidation, format, args...)
+}
+
+func Unauthorized(format string, args ...interface{}) *Error {
+	return New(KindUnauthorized, format, args...)
+}
+
+func Forbidden(format string, args ...interface{}) *Error {
+	return New(KindForbidden, format, args...)
+}
+
+func NotFound(format string, args ...interface{}) *Error {
+	return New(KindNotFound, format, args...)
+}
+
+func Conflict(format string, args ...interface{}) *Error {
+	return New(KindConflict, format, args...)
+}
+
+func PreconditionFailed(format string, args ...interface{}) *Error {
+	return New(KindPrecondition, format, args...)
+}
+
+func Internal(err error, format string, args ...interface{}) *Error {
+	return Wrap(err, KindInternal, format, args...)
+}
+
+// As returns the *Error in err's chain, or wraps err as an internal error when there is none.
+func As(err error) *Error {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+	return Internal(err, "Internal server error")
+}
+
+// Is reports whether err's chain contains an *Error of the given kind.
+func Is(err error, kind Kind) bool {
+	var appErr *Error
+	return errors.As(err, &appErr) && appErr.Kind == kind
+}
+
+// Binding converts a gin binding error: oversized bodies become too-large errors and
+// validator failures list the failing tag per field.
+func Binding(err error) *Error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return Wrap(err, KindTooLarge, "Request body exceeds the maximum upload size")
+	}
+
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		fields := make(map[string]string, len(validationErrs))
+		for _, fieldErr := range validationErrs {
+			fields[strings.ToLower(fieldErr.Field())] = fieldErr.Tag()
+		}
+		return Validation("Request validation failed").With("fields", fields)
+	}
+
+	return Wrap(err, KindValidation, "Failed to bind request")
+}
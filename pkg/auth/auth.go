@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"strings"
+)
+
+// claimsKey is the gin.Context key the authenticated claims are stored under.
+const claimsKey = "auth.claims"
+
+// Claims are the JWT claims the API understands. Subject identifies the user.
+type Claims struct {
+	jwt.RegisteredClaims
+	Name  string   `json:"name,omitempty"`
+	Roles []string `json:"roles,omitempty"`
+	// Scope is the space-separated OAuth 2.0 scope list.
+	Scope string `json:"scope,omitempty"`
+}
+
+// Scopes splits Scope into its entries.
+func (c *Claims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// SetClaims stores the claims of the authenticated caller on the request context.
+func SetClaims(c *gin.Context, claims *Claims) {
+	c.Set(claimsKey, claims)
+}
+
+// GetClaims returns the claims stored by SetClaims, if the request was authenticated.
+func GetClaims(c *gin.Context) (*Claims, bool) {
+	value, ok := c.Get(claimsKey)
+	if !ok {
+		return nil, false
+	}
+	claims, ok := value.(*Claims)
+	return claims, ok
+}
+
+// BearerToken extracts the token of an "Authorization: Bearer <token>" header.
+func BearerToken(c *gin.Context) (string, bool) {
+	scheme, token, ok := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+)
+
+// principalKey is the gin.Context key the authenticated caller is stored under.
+const principalKey = "auth.principal"
+
+// APIKeyHeader carries the API key of service-to-service calls.
+const APIKeyHeader = "X-API-Key"
+
+const (
+	PrincipalUser      = "user"
+	PrincipalAPIKey    = "api_key"
+	PrincipalAnonymous = "anonymous"
+	// PrincipalSystem is the server itself, e.g. background jobs. It may do anything.
+	PrincipalSystem = "system"
+)
+
+// Scopes an API key can be granted.
+const (
+	ScopeBeersRead       = "beers:read"
+	ScopeBeersWrite      = "beers:write"
+	ScopeCategoriesWrite = "categories:write"
+)
+
+// KnownScopes lists every scope an API key can be granted.
+var KnownScopes = []string{ScopeBeersRead, ScopeBeersWrite, ScopeCategoriesWrite}
+
+// Principal is the caller of a request: a user with a JWT, an API key or an anonymous visitor.
+type Principal struct {
+	Kind string `json:"kind"`
+	// ID is the JWT subject for users and the key ID for API keys.
+	ID     string   `json:"id"`
+	Name   string   `json:"name,omitempty"`
+	Scopes []string `json:"scopes,omitempty"`
+	// Roles and Permissions are filled in by an IPolicy.
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+}
+
+// Can reports whether the principal holds permission.
+func (p *Principal) Can(permission string) bool {
+	if p.Kind == PrincipalSystem {
+		return true
+	}
+	for _, granted := range p.Permissions {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// IKeyAuthenticator resolves an API key to the principal it belongs to.
+type IKeyAuthenticator interface {
+	AuthenticateKey(ctx context.Context, key string) (*Principal, error)
+}
+
+func SetPrincipal(c *gin.Context, principal *Principal) {
+	c.Set(principalKey, principal)
+}
+
+// GetPrincipal returns the caller stored by SetPrincipal, if the request was authenticated.
+func GetPrincipal(c *gin.Context) (*Principal, bool) {
+	value, ok := c.Get(principalKey)
+	if !ok {
+		return nil, false
+	}
+	principal, ok := value.(*Principal)
+	return principal, ok
+}
+
+// UserPrincipal is the principal of a user authenticated with a JWT.
+func UserPrincipal(claims *Claims) *Principal {
+	return &Principal{
+		Kind:   PrincipalUser,
+		ID:     claims.Subject,
+		Name:   claims.Name,
+		Scopes: claims.Scopes(),
+	}
+}
+
+// AnonymousPrincipal is the principal of a request without credentials.
+func AnonymousPrincipal() *Principal {
+	return &Principal{Kind: PrincipalAnonymous}
+}
@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"context"
+	"github.com/peedans/beerleo/pkg/apperrors"
+)
+
+// Permissions checked by the API. Which roles hold them is stored in the database.
+const (
+	PermBeersRead       = "beers:read"
+	PermBeersCreate     = "beers:create"
+	PermBeersUpdate     = "beers:update"
+	PermBeersDelete     = "beers:delete"
+	PermBeersRestore    = "beers:restore"
+	PermBeersImport     = "beers:import"
+	PermCategoriesWrite = "categories:write"
+	PermApiKeysManage   = "api_keys:manage"
+	PermRolesManage     = "roles:manage"
+	PermAuditRead       = "audit:read"
+)
+
+// IPolicy fills in the roles and permissions of a principal.
+type IPolicy interface {
+	Resolve(principal *Principal) error
+}
+
+type principalContextKey struct{}
+
+// SystemPrincipal is used for work the server does on its own, such as purging old beers.
+var SystemPrincipal = &Principal{Kind: PrincipalSystem, ID: "system", Name: "system"}
+
+// WithPrincipal returns a copy of ctx carrying principal, for the usecases to check.
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal stored by WithPrincipal.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalContextKey{}).(*Principal)
+	return principal, ok && principal != nil
+}
+
+// Authorize fails unless ctx carries a principal holding permission.
+func Authorize(ctx context.Context, permission string) error {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return apperrors.Unauthorized("Authentication required")
+	}
+	if !principal.Can(permission) {
+		return apperrors.Forbidden("Permission %q is required", permission)
+	}
+	return nil
+}
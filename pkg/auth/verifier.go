@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/peedans/beerleo/config"
+	"math/big"
+	"os"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrNoKeys       = errors.New("no JWT verification keys configured")
+)
+
+type IVerifier interface {
+	// Verify checks the token's signature and registered claims and returns its claims.
+	Verify(token string) (*Claims, error)
+}
+
+type verifier struct {
+	hmacSecret []byte
+	// rsaKeys holds RS256 keys by key ID; the key of a PEM file has the ID "".
+	rsaKeys map[string]*rsa.PublicKey
+	parser  *jwt.Parser
+}
+
+// Verifier builds a verifier from the configured keys: an HS256 secret, an RS256 public key
+// in PEM format and an RS256 JWKS file may be combined. Only algorithms with a key are accepted.
+func Verifier(cfg config.IJwtConfig) (IVerifier, error) {
+	v := &verifier{
+		hmacSecret: cfg.HMACSecret(),
+		rsaKeys:    make(map[string]*rsa.PublicKey),
+	}
+
+	if path := cfg.RSAPublicKeyFile(); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read rsa public key: %w", err)
+		}
+		key, err := jwt.ParseRSAPublicKeyFromPEM(data)
+		if err != nil {
+			return nil, fmt.Errorf("parse rsa public key %s: %w", path, err)
+		}
+		v.rsaKeys[""] = key
+	}
+
+	if path := cfg.JWKSFile(); path != "" {
+		keys, err := loadJWKS(path)
+		if err != nil {
+			return nil, err
+		}
+		for kid, key := range keys {
+			v.rsaKeys[kid] = key
+		}
+	}
+
+	var methods []string
+	if len(v.hmacSecret) > 0 {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if len(v.rsaKeys) > 0 {
+		methods = append(methods, jwt.SigningMethodRS256.Alg())
+	}
+	if len(methods) == 0 {
+		return nil, ErrNoKeys
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithLeeway(cfg.Leeway()),
+		jwt.WithExpirationRequired(),
+	}
+	if cfg.Issuer() != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer()))
+	}
+	if cfg.Audience() != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience()))
+	}
+	v.parser = jwt.NewParser(opts...)
+
+	return v, nil
+}
+
+func (v *verifier) Verify(token string) (*Claims, error) {
+	claims := &Claims{}
+	if _, err := v.parser.ParseWithClaims(token, claims, v.key); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return claims, nil
+}
+
+// key picks the verification key for the token's algorithm and key ID.
+func (v *verifier) key(token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		return v.hmacSecret, nil
+	case *jwt.SigningMethodRSA:
+		kid, _ := token.Header["kid"].(string)
+		if key, ok := v.rsaKeys[kid]; ok {
+			return key, nil
+		}
+		// A token without a key ID is accepted when there is only one key to try.
+		if kid == "" && len(v.rsaKeys) == 1 {
+			for _, key := range v.rsaKeys {
+				return key, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	default:
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+}
+
+// jwks is a JSON Web Key Set (RFC 7517); only RSA signing keys are used.
+type jwks struct {
+	Keys []struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		Alg string `json:"alg"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	} `json:"keys"`
+}
+
+func loadJWKS(path string) (map[string]*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read jwks: %w", err)
+	}
+	var set jwks
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parse jwks %s: %w", path, err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") || (jwk.Alg != "" && jwk.Alg != "RS256") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("parse jwks %s: key %q: invalid modulus: %w", path, jwk.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("parse jwks %s: key %q: invalid exponent", path, jwk.Kid)
+		}
+		keys[jwk.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("jwks %s contains no RS256 signing keys", path)
+	}
+	return keys, nil
+}
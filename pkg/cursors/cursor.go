@@ -0,0 +1,63 @@
+package cursors
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrExpiredCursor = errors.New("expired cursor")
+)
+
+// Cursor is the position of a row in a keyset-paginated listing: the values of its sort
+// key (ending with the id) and the sort the values belong to. Expires is a unix time after
+// which the cursor is refused; zero never expires.
+type Cursor struct {
+	Sort    string   `json:"s"`
+	Values  []string `json:"v"`
+	Expires int64    `json:"e,omitempty"`
+}
+
+// Encode returns an opaque token: the base64url JSON payload and its HMAC-SHA256 signature.
+func Encode(secret []byte, cursor *Cursor) (string, error) {
+	payload, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + sign(secret, encoded), nil
+}
+
+// Decode verifies the token's signature and expiry at now and returns the cursor it carries.
+func Decode(secret []byte, token string, now time.Time) (*Cursor, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(sign(secret, encoded))) {
+		return nil, ErrInvalidCursor
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor Cursor
+	if err := json.Unmarshal(payload, &cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if cursor.Expires != 0 && now.Unix() >= cursor.Expires {
+		return nil, ErrExpiredCursor
+	}
+	return &cursor, nil
+}
+
+func sign(secret []byte, encoded string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,69 @@
+package cursors
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	sign := func(cursor *Cursor) string {
+		token, err := Encode(secret, cursor)
+		if err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		return token
+	}
+	valid := sign(&Cursor{Sort: "-score,id", Values: []string{"1.250000", "42"}, Expires: now.Add(time.Hour).Unix()})
+	payload, signature, _ := strings.Cut(valid, ".")
+	forged, _, _ := strings.Cut(sign(&Cursor{Sort: "-score,id", Values: []string{"9.000000", "1"}}), ".")
+
+	expired := sign(&Cursor{Sort: "id", Values: []string{"7"}, Expires: now.Unix()})
+	_, expiredSignature, _ := strings.Cut(expired, ".")
+	extended, _, _ := strings.Cut(sign(&Cursor{Sort: "id", Values: []string{"7"}, Expires: now.Add(time.Hour).Unix()}), ".")
+
+	tests := []struct {
+		name    string
+		secret  []byte
+		token   string
+		want    *Cursor
+		wantErr error
+	}{
+		{
+			name:   "round trip",
+			secret: secret,
+			token:  valid,
+			want:   &Cursor{Sort: "-score,id", Values: []string{"1.250000", "42"}, Expires: now.Add(time.Hour).Unix()},
+		},
+		{
+			name:   "no expiry",
+			secret: secret,
+			token:  sign(&Cursor{Sort: "id", Values: []string{"7"}}),
+			want:   &Cursor{Sort: "id", Values: []string{"7"}},
+		},
+		{name: "tampered payload", secret: secret, token: forged + "." + signature, wantErr: ErrInvalidCursor},
+		{name: "tampered signature", secret: secret, token: payload + "." + strings.Repeat("A", len(signature)), wantErr: ErrInvalidCursor},
+		{name: "wrong secret", secret: []byte("other"), token: valid, wantErr: ErrInvalidCursor},
+		{name: "missing signature", secret: secret, token: payload, wantErr: ErrInvalidCursor},
+		{name: "empty", secret: secret, token: "", wantErr: ErrInvalidCursor},
+		{name: "expired", secret: secret, token: expired, wantErr: ErrExpiredCursor},
+		{name: "expiry extended by tampering", secret: secret, token: extended + "." + expiredSignature, wantErr: ErrInvalidCursor},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Decode(tt.secret, tt.token, now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Decode err = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Decode = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -4,13 +4,13 @@ import (
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
 	"github.com/peedans/beerleo/config"
-	"log"
+	"github.com/peedans/beerleo/pkg/logger"
 )
 
 func DbConnect(cfg config.IDbConfig) *sqlx.DB {
 	db, err := sqlx.Connect("mysql", cfg.Url())
 	if err != nil {
-		log.Fatalf("connect to db failed: %v\n", err)
+		logger.Fatal("connect to db failed", "err", err)
 	}
 	db.DB.SetMaxOpenConns(cfg.MaxOpenConns())
 	return db
@@ -0,0 +1 @@
+DELETE FROM beers WHERE name IN ('Lager Lite', 'Golden Ale', 'Hoppy IPA', 'Dark Knight Stout');
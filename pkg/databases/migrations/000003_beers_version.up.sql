@@ -0,0 +1 @@
+ALTER TABLE beers ADD COLUMN version INT NOT NULL DEFAULT 1;
@@ -0,0 +1 @@
+ALTER TABLE beers DROP INDEX ft_beers_search;
@@ -0,0 +1,2 @@
+-- ngram splits text into 2-character tokens (ngram_token_size), so Thai without spaces is searchable.
+ALTER TABLE beers ADD FULLTEXT INDEX ft_beers_search (name, category, detail) WITH PARSER ngram;
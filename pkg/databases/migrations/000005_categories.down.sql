@@ -0,0 +1,3 @@
+ALTER TABLE beers DROP FOREIGN KEY fk_beers_category;
+ALTER TABLE beers DROP COLUMN category_id;
+DROP TABLE categories;
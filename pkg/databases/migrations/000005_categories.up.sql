@@ -0,0 +1,28 @@
+CREATE TABLE categories (
+                            id BIGINT AUTO_INCREMENT PRIMARY KEY,
+                            name VARCHAR(255) NOT NULL,
+                            slug VARCHAR(255) NOT NULL,
+                            parent_id BIGINT NULL,
+                            created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                            updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+                            UNIQUE KEY uq_categories_slug (slug),
+                            CONSTRAINT fk_categories_parent FOREIGN KEY (parent_id) REFERENCES categories (id)
+);
+
+-- One category per slug, so spellings that differ only in case, spacing or punctuation ("IPA", "ipa ",
+-- "Ipa!") collapse into one. Synonyms such as "India Pale Ale" are combined afterwards with
+-- POST /v1/categories/:id/merge. The slug expression must match categories.Slugify.
+INSERT INTO categories (name, slug)
+SELECT MIN(TRIM(category)), slug
+FROM (SELECT category, TRIM(BOTH '-' FROM REGEXP_REPLACE(LOWER(TRIM(category)), '[^\\p{L}\\p{M}\\p{N}]+', '-')) AS slug FROM beers) normalized
+WHERE slug <> ''
+GROUP BY slug;
+
+ALTER TABLE beers
+    ADD COLUMN category_id BIGINT NULL,
+    ADD CONSTRAINT fk_beers_category FOREIGN KEY (category_id) REFERENCES categories (id);
+
+-- beers.category is kept as a copy of the category name for full-text search and sorting.
+UPDATE beers b
+    JOIN categories c ON c.slug = TRIM(BOTH '-' FROM REGEXP_REPLACE(LOWER(TRIM(b.category)), '[^\\p{L}\\p{M}\\p{N}]+', '-'))
+SET b.category_id = c.id, b.category = c.name;
@@ -0,0 +1,15 @@
+CREATE TABLE api_keys (
+                          id BIGINT AUTO_INCREMENT PRIMARY KEY,
+                          name VARCHAR(255) NOT NULL,
+                          -- prefix is the public part of the key used to look it up; the secret is only kept as a bcrypt hash.
+                          prefix VARCHAR(32) NOT NULL,
+                          key_hash VARCHAR(255) NOT NULL,
+                          scopes VARCHAR(255) NOT NULL,
+                          created_by VARCHAR(255) NOT NULL DEFAULT '',
+                          expires_at TIMESTAMP NULL,
+                          last_used_at TIMESTAMP NULL,
+                          revoked_at TIMESTAMP NULL,
+                          created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                          updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+                          UNIQUE KEY uq_api_keys_prefix (prefix)
+);
@@ -0,0 +1,71 @@
+CREATE TABLE roles (
+                       id BIGINT AUTO_INCREMENT PRIMARY KEY,
+                       name VARCHAR(64) NOT NULL,
+                       description VARCHAR(255) NOT NULL DEFAULT '',
+                       UNIQUE KEY uq_roles_name (name)
+);
+
+CREATE TABLE permissions (
+                             id BIGINT AUTO_INCREMENT PRIMARY KEY,
+                             name VARCHAR(64) NOT NULL,
+                             description VARCHAR(255) NOT NULL DEFAULT '',
+                             UNIQUE KEY uq_permissions_name (name)
+);
+
+CREATE TABLE role_permissions (
+                                  role_id BIGINT NOT NULL,
+                                  permission_id BIGINT NOT NULL,
+                                  PRIMARY KEY (role_id, permission_id),
+                                  CONSTRAINT fk_role_permissions_role FOREIGN KEY (role_id) REFERENCES roles (id) ON DELETE CASCADE,
+                                  CONSTRAINT fk_role_permissions_permission FOREIGN KEY (permission_id) REFERENCES permissions (id) ON DELETE CASCADE
+);
+
+-- Roles granted to users, by JWT subject. These are all the roles a user holds; a roles claim in the token is ignored.
+CREATE TABLE user_roles (
+                            subject VARCHAR(255) NOT NULL,
+                            role_id BIGINT NOT NULL,
+                            created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                            PRIMARY KEY (subject, role_id),
+                            CONSTRAINT fk_user_roles_role FOREIGN KEY (role_id) REFERENCES roles (id) ON DELETE CASCADE
+);
+
+INSERT INTO permissions (name, description) VALUES
+    ('beers:read', 'List, search, view and export beers'),
+    ('beers:create', 'Create beers'),
+    ('beers:update', 'Update beers'),
+    ('beers:delete', 'Delete beers'),
+    ('beers:restore', 'Restore deleted beers and see the trash'),
+    ('beers:import', 'Bulk import beers'),
+    ('categories:write', 'Create, update, delete and merge categories'),
+    ('api_keys:manage', 'Create, rotate and revoke API keys'),
+    ('roles:manage', 'Assign roles to users');
+
+-- anonymous applies to requests without credentials. API keys hold the roles named after their
+-- scopes, so what a scope allows can be changed here like any other role.
+INSERT INTO roles (name, description) VALUES
+    ('anonymous', 'Requests without credentials'),
+    ('viewer', 'Reads the catalog'),
+    ('editor', 'Creates and updates beers and categories'),
+    ('admin', 'Deletes and restores beers and manages access'),
+    ('beers:read', 'API key scope beers:read'),
+    ('beers:write', 'API key scope beers:write'),
+    ('categories:write', 'API key scope categories:write');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r
+         JOIN permissions p ON (r.name, p.name) IN (
+                                                    ('anonymous', 'beers:read'),
+                                                    ('viewer', 'beers:read'),
+                                                    ('editor', 'beers:read'),
+                                                    ('editor', 'beers:create'),
+                                                    ('editor', 'beers:update'),
+                                                    ('editor', 'beers:import'),
+                                                    ('editor', 'categories:write'),
+                                                    ('beers:read', 'beers:read'),
+                                                    ('beers:write', 'beers:read'),
+                                                    ('beers:write', 'beers:create'),
+                                                    ('beers:write', 'beers:update'),
+                                                    ('beers:write', 'beers:import'),
+                                                    ('categories:write', 'categories:write'))
+   OR r.name = 'admin';
@@ -0,0 +1,23 @@
+-- beer_id has no foreign key: the log must outlive beers that are purged.
+CREATE TABLE audit_log (
+                           id BIGINT AUTO_INCREMENT PRIMARY KEY,
+                           actor_kind VARCHAR(32) NOT NULL DEFAULT '',
+                           actor_id VARCHAR(255) NOT NULL DEFAULT '',
+                           actor_name VARCHAR(255) NOT NULL DEFAULT '',
+                           action VARCHAR(64) NOT NULL,
+                           beer_id BIGINT NULL,
+                           request_id VARCHAR(128) NOT NULL DEFAULT '',
+                           client_ip VARCHAR(45) NOT NULL DEFAULT '',
+                           before_json JSON NULL,
+                           after_json JSON NULL,
+                           created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                           KEY idx_audit_log_created_at (created_at),
+                           KEY idx_audit_log_beer (beer_id, created_at),
+                           KEY idx_audit_log_actor (actor_id, created_at)
+);
+
+INSERT INTO permissions (name, description) VALUES ('audit:read', 'Read the audit log');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r JOIN permissions p ON p.name = 'audit:read'
+WHERE r.name = 'admin';
@@ -0,0 +1 @@
+DROP TABLE beer_revisions;
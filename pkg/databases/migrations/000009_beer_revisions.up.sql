@@ -0,0 +1,15 @@
+-- A revision is the beer as it was at its version `revision`, saved just before an update replaced it.
+CREATE TABLE beer_revisions (
+                                id BIGINT AUTO_INCREMENT PRIMARY KEY,
+                                beer_id BIGINT NOT NULL,
+                                revision INT NOT NULL,
+                                name VARCHAR(255) NOT NULL,
+                                category VARCHAR(255) NOT NULL,
+                                category_id BIGINT NULL,
+                                detail TEXT NOT NULL,
+                                image TEXT NOT NULL,
+                                created_by VARCHAR(255) NOT NULL DEFAULT '',
+                                created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                UNIQUE KEY uq_beer_revisions_revision (beer_id, revision),
+                                CONSTRAINT fk_beer_revisions_beer FOREIGN KEY (beer_id) REFERENCES beers (id) ON DELETE CASCADE
+);
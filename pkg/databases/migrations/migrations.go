@@ -0,0 +1,387 @@
+// Package migrations applies the embedded schema migrations and tracks them in schema_migrations.
+//
+// A database created before migrations existed already has the tables of the first migrations.
+// Run `migrate baseline N` once against it to record migrations up to N as applied without
+// running them, then `migrate up` as usual.
+package migrations
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+var fileNamePattern = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+type IMigrator interface {
+	Up() error
+	Down() error
+	To(version int) error
+	Baseline(version int) error
+	Status() ([]*MigrationStatus, error)
+}
+
+type migrator struct {
+	db         *sqlx.DB
+	migrations []*Migration
+}
+
+func Migrator(db *sqlx.DB) (IMigrator, error) {
+	migrations, err := load(files)
+	if err != nil {
+		return nil, err
+	}
+	return &migrator{
+		db:         db,
+		migrations: migrations,
+	}, nil
+}
+
+// load reads every embedded up/down pair and returns them ordered by version.
+func load(fsys fs.FS) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := fileNamePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		version, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version %q: %w", entry.Name(), err)
+		}
+		body, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if match[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if strings.TrimSpace(m.Up) == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+func (m *migrator) ensureTable() error {
+	_, err := m.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT NOT NULL PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	return err
+}
+
+func (m *migrator) applied() (map[int]time.Time, error) {
+	if err := m.ensureTable(); err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		Version   int       `db:"version"`
+		AppliedAt time.Time `db:"applied_at"`
+	}
+	if err := m.db.Select(&rows, "SELECT version, applied_at FROM schema_migrations"); err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]time.Time, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row.AppliedAt
+	}
+	return applied, nil
+}
+
+// Up applies every pending migration in order.
+func (m *migrator) Up() error {
+	if len(m.migrations) == 0 {
+		return nil
+	}
+	return m.To(m.migrations[len(m.migrations)-1].Version)
+}
+
+// Down rolls back the most recently applied migration.
+func (m *migrator) Down() error {
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		if _, ok := applied[m.migrations[i].Version]; ok {
+			return m.rollback(m.migrations[i])
+		}
+	}
+	return nil
+}
+
+// To migrates up or down until exactly the migrations up to version are applied.
+// Version 0 rolls back everything.
+func (m *migrator) To(version int) error {
+	if version != 0 && m.find(version) == nil {
+		return fmt.Errorf("unknown migration version %d", version)
+	}
+
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		mig := m.migrations[i]
+		if _, ok := applied[mig.Version]; ok && mig.Version > version {
+			if err := m.rollback(mig); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, mig := range m.migrations {
+		if _, ok := applied[mig.Version]; !ok && mig.Version <= version {
+			if err := m.apply(mig); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Baseline records the migrations up to version as applied without running them, for a database
+// whose schema already matches them. It refuses once any migration is recorded.
+func (m *migrator) Baseline(version int) error {
+	if m.find(version) == nil {
+		return fmt.Errorf("unknown migration version %d", version)
+	}
+
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+	if len(applied) > 0 {
+		return fmt.Errorf("cannot baseline: %d migrations are already applied", len(applied))
+	}
+
+	tx, err := m.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, mig := range m.migrations {
+		if mig.Version > version {
+			break
+		}
+		if _, err := tx.Exec("INSERT INTO schema_migrations(version, name) VALUES (?, ?)", mig.Version, mig.Name); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (m *migrator) Status() ([]*MigrationStatus, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]*MigrationStatus, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		status := &MigrationStatus{Version: mig.Version, Name: mig.Name}
+		if at, ok := applied[mig.Version]; ok {
+			status.AppliedAt = &at
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func (m *migrator) find(version int) *Migration {
+	for _, mig := range m.migrations {
+		if mig.Version == version {
+			return mig
+		}
+	}
+	return nil
+}
+
+// apply runs the up script and records the version. MySQL commits DDL implicitly,
+// so the transaction only protects data statements and the bookkeeping row.
+func (m *migrator) apply(mig *Migration) error {
+	tx, err := m.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range splitStatements(mig.Up) {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("migration %d_%s up failed: %w", mig.Version, mig.Name, err)
+		}
+	}
+	if _, err := tx.Exec("INSERT INTO schema_migrations(version, name) VALUES (?, ?)", mig.Version, mig.Name); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m *migrator) rollback(mig *Migration) error {
+	tx, err := m.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range splitStatements(mig.Down) {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("migration %d_%s down failed: %w", mig.Version, mig.Name, err)
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM schema_migrations WHERE version=?", mig.Version); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// splitStatements splits a script on semicolons that are outside of quotes and comments,
+// so scripts work without enabling multiStatements on the driver.
+func splitStatements(script string) []string {
+	var (
+		statements []string
+		current    strings.Builder
+		quote      rune
+	)
+
+	flush := func() {
+		stmt := strings.TrimSpace(current.String())
+		if stmt != "" {
+			statements = append(statements, stmt)
+		}
+		current.Reset()
+	}
+
+	runes := []rune(script)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote != 0:
+			current.WriteRune(r)
+			if r == '\\' && i+1 < len(runes) {
+				i++
+				current.WriteRune(runes[i])
+			} else if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+			current.WriteRune(r)
+		case r == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+			current.WriteRune('\n')
+		case r == ';':
+			flush()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush()
+
+	return statements
+}
+
+const usage = `usage: migrate up|down|status|to N|baseline N
+  up          apply every pending migration
+  down        roll back the last applied migration
+  status      list migrations and when they were applied
+  to N        migrate up or down to version N (0 rolls back everything)
+  baseline N  record migrations up to N as applied without running them,
+              for a database created before migrations were introduced`
+
+// Run executes a migrate subcommand: up, down, status, to N or baseline N.
+func Run(m IMigrator, args []string) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	switch args[0] {
+	case "up":
+		return m.Up()
+	case "down":
+		return m.Down()
+	case "to", "baseline":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: migrate %s N", args[0])
+		}
+		version, err := strconv.Atoi(args[1])
+		if err != nil || version < 0 {
+			return fmt.Errorf("invalid migration version %q", args[1])
+		}
+		if args[0] == "baseline" {
+			return m.Baseline(version)
+		}
+		return m.To(version)
+	case "status":
+		statuses, err := m.Status()
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%06d  %-40s %s\n", status.Version, status.Name, applied)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q\n%s", args[0], usage)
+	}
+}
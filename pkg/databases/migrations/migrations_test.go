@@ -0,0 +1,55 @@
+package migrations
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{name: "empty", script: "", want: nil},
+		{name: "only whitespace and separators", script: " ;\n; ", want: nil},
+		{name: "single without semicolon", script: "SELECT 1", want: []string{"SELECT 1"}},
+		{
+			name:   "several",
+			script: "CREATE TABLE a (id INT);\nCREATE TABLE b (id INT);\n",
+			want:   []string{"CREATE TABLE a (id INT)", "CREATE TABLE b (id INT)"},
+		},
+		{
+			name:   "semicolon in single quotes",
+			script: "INSERT INTO a VALUES ('x;y');SELECT 1",
+			want:   []string{"INSERT INTO a VALUES ('x;y')", "SELECT 1"},
+		},
+		{
+			name:   "semicolon in double quotes and backticks",
+			script: "SELECT \"a;b\";SELECT `c;d`",
+			want:   []string{"SELECT \"a;b\"", "SELECT `c;d`"},
+		},
+		{
+			name:   "escaped quote",
+			script: `INSERT INTO a VALUES ('it\'s;fine');SELECT 1`,
+			want:   []string{`INSERT INTO a VALUES ('it\'s;fine')`, "SELECT 1"},
+		},
+		{
+			name:   "line comment with semicolon",
+			script: "-- drop; everything\nSELECT 1;\nSELECT 2 -- trailing; comment\n",
+			want:   []string{"SELECT 1", "SELECT 2"},
+		},
+		{
+			name:   "dashes in a string are not a comment",
+			script: "SELECT '--;';SELECT 1",
+			want:   []string{"SELECT '--;'", "SELECT 1"},
+		},
+	}
+
+	for _, tt := range tests {
+		got := splitStatements(tt.script)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: splitStatements(%q) = %q, want %q", tt.name, tt.script, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package highlights
+
+import (
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// NgramSize matches MySQL's default ngram_token_size, the unit the full-text index matches on.
+const NgramSize = 2
+
+// Terms splits a search query into the whitespace-separated terms to highlight.
+func Terms(query string) []string {
+	return strings.FieldsFunc(strings.ToLower(query), unicode.IsSpace)
+}
+
+// Snippet returns an HTML-escaped excerpt of text around the first match of any term, with every
+// match wrapped in <mark>. Terms that do not occur verbatim, as is common for Thai where the
+// index matched on n-grams, fall back to their n-grams. ok is false when nothing matched.
+func Snippet(text string, terms []string, radius int) (snippet string, ok bool) {
+	runes := []rune(text)
+	lower := []rune(strings.ToLower(text))
+	if len(lower) != len(runes) {
+		lower = runes
+	}
+
+	matches := find(lower, terms)
+	if len(matches) == 0 {
+		matches = find(lower, ngrams(terms))
+	}
+	if len(matches) == 0 {
+		return "", false
+	}
+
+	start := maxInt(0, matches[0].start-radius)
+	end := minInt(len(runes), matches[0].end+radius)
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	pos := start
+	for _, m := range matches {
+		if m.start < pos || m.end > end {
+			continue
+		}
+		b.WriteString(html.EscapeString(string(runes[pos:m.start])))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(string(runes[m.start:m.end])))
+		b.WriteString("</mark>")
+		pos = m.end
+	}
+	b.WriteString(html.EscapeString(string(runes[pos:end])))
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+
+	return b.String(), true
+}
+
+type match struct {
+	start, end int
+}
+
+// find returns the non-overlapping matches of terms in text, ordered by position.
+func find(text []rune, terms []string) []match {
+	var matches []match
+	for _, term := range terms {
+		needle := []rune(term)
+		if len(needle) == 0 {
+			continue
+		}
+		for i := 0; i+len(needle) <= len(text); i++ {
+			if string(text[i:i+len(needle)]) == term {
+				matches = append(matches, match{i, i + len(needle)})
+				i += len(needle) - 1
+			}
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].start == matches[j].start {
+			return matches[i].end > matches[j].end
+		}
+		return matches[i].start < matches[j].start
+	})
+
+	merged := matches[:0]
+	for _, m := range matches {
+		if len(merged) > 0 && m.start < merged[len(merged)-1].end {
+			if m.end > merged[len(merged)-1].end {
+				merged[len(merged)-1].end = m.end
+			}
+			continue
+		}
+		merged = append(merged, m)
+	}
+	return merged
+}
+
+func ngrams(terms []string) []string {
+	var grams []string
+	for _, term := range terms {
+		if utf8.RuneCountInString(term) <= NgramSize {
+			continue
+		}
+		runes := []rune(term)
+		for i := 0; i+NgramSize <= len(runes); i++ {
+			grams = append(grams, string(runes[i:i+NgramSize]))
+		}
+	}
+	return grams
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,29 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"os"
+)
+
+type contextKey struct{}
+
+// With returns a copy of ctx carrying l, normally the request-scoped logger.
+func With(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// From returns the logger stored by With, or the default logger for work that is not tied to
+// a request, such as background jobs.
+func From(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// Fatal logs msg at error level with the default logger and exits.
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const namespace = "beerleo"
+
+// Upload failure reasons.
+const (
+	UploadTooLarge        = "too_large"
+	UploadUnsupportedType = "unsupported_type"
+	UploadInvalid         = "invalid"
+	UploadProcessing      = "processing"
+	UploadStorage         = "storage"
+)
+
+// registry holds every metric of the process. Metrics are process-wide, so they are package
+// variables rather than being threaded through the modules.
+var registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "HTTP requests served, by method, route template and status.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Time taken to serve HTTP requests, by method, route template and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	queryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "repository",
+		Name:      "query_duration_seconds",
+		Help:      "Time taken by repository methods, by repository and method.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"repository", "method"})
+
+	uploadBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "image",
+		Name:      "upload_bytes_total",
+		Help:      "Bytes of uploaded images stored, not counting resized variants.",
+	})
+
+	uploadFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "image",
+		Name:      "upload_failures_total",
+		Help:      "Image uploads that were rejected or could not be stored, by reason.",
+	}, []string{"reason"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests,
+		httpDuration,
+		queryDuration,
+		uploadBytes,
+		uploadFailures,
+	)
+}
+
+// RegisterDB exports the connection pool stats of db (open, in use, idle, wait count and time)
+// as go_sql_* metrics. Call it once per pool.
+func RegisterDB(name string, db *sqlx.DB) {
+	registry.MustRegister(collectors.NewDBStatsCollector(db.DB, name))
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{Registry: registry})
+}
+
+// ObserveRequest records a served HTTP request. route is the route template, e.g.
+// /v1/beers/:id, so that IDs do not turn into labels.
+func ObserveRequest(method, route string, status int, duration time.Duration) {
+	labels := []string{method, route, strconv.Itoa(status)}
+	httpRequests.WithLabelValues(labels...).Inc()
+	httpDuration.WithLabelValues(labels...).Observe(duration.Seconds())
+}
+
+// ObserveQuery starts timing a repository method and returns the function that records it:
+//
+//	defer metrics.ObserveQuery("beers", "GetBeerByID")()
+func ObserveQuery(repository, method string) func() {
+	start := time.Now()
+	return func() {
+		queryDuration.WithLabelValues(repository, method).Observe(time.Since(start).Seconds())
+	}
+}
+
+// UploadStored counts the bytes of a stored image upload.
+func UploadStored(bytes int) {
+	uploadBytes.Add(float64(bytes))
+}
+
+// UploadFailed counts an image upload that failed for reason, one of the Upload* constants.
+func UploadFailed(reason string) {
+	uploadFailures.WithLabelValues(reason).Inc()
+}
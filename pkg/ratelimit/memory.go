@@ -0,0 +1,48 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// sweepEvery is how often the memory store drops buckets that have refilled.
+const sweepEvery = time.Minute
+
+type memoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// MemoryStore keeps buckets in process memory, so each instance limits on its own.
+func MemoryStore() IStore {
+	return &memoryStore{
+		buckets: make(map[string]*bucket),
+	}
+}
+
+func (s *memoryStore) Take(key string, limit Limit, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{}
+		s.buckets[key] = b
+	}
+	return b.take(limit, now), nil
+}
+
+// sweep drops full buckets, which are no different from missing ones.
+func (s *memoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepEvery {
+		return
+	}
+	s.lastSweep = now
+	for key, b := range s.buckets {
+		if !b.full.After(now) {
+			delete(s.buckets, key)
+		}
+	}
+}
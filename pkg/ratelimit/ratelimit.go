@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"time"
+)
+
+// Limit is a token bucket: it holds up to Burst tokens and refills at Requests per Period.
+// Every request takes one token.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+	Burst    int
+}
+
+// interval is the time it takes to refill one token.
+func (l Limit) interval() time.Duration {
+	return l.Period / time.Duration(l.Requests)
+}
+
+// Result is the outcome of taking a token, and what the RateLimit-* headers report.
+type Result struct {
+	Allowed bool
+	// Limit is the bucket's capacity.
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until a token is available; zero when Allowed.
+	RetryAfter time.Duration
+}
+
+// IStore keeps the buckets. Stores shared between instances (e.g. Redis) can implement it so
+// every instance enforces the same limits.
+type IStore interface {
+	// Take takes a token from the bucket for key, creating a full bucket if there is none.
+	Take(key string, limit Limit, now time.Time) (Result, error)
+}
+
+// bucket is the state of one key. Rather than a token count it keeps the time at which the
+// bucket will be full again, which needs no background refill ("generic cell rate algorithm").
+type bucket struct {
+	full time.Time
+}
+
+// take takes a token from b if there is one, and reports the bucket's state afterwards.
+func (b *bucket) take(limit Limit, now time.Time) Result {
+	interval := limit.interval()
+	capacity := time.Duration(limit.Burst) * interval
+
+	full := b.full
+	if full.Before(now) {
+		full = now
+	}
+	// After taking a token the bucket is full one interval later; that may not be further than
+	// capacity from now.
+	next := full.Add(interval)
+	result := Result{Limit: limit.Burst}
+	if over := next.Sub(now) - capacity; over > 0 {
+		result.RetryAfter = over
+	} else {
+		result.Allowed = true
+		b.full = next
+		full = next
+	}
+
+	result.Reset = full.Sub(now)
+	result.Remaining = int((capacity - result.Reset) / interval)
+	return result
+}
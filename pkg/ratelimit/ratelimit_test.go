@@ -0,0 +1,115 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreTake(t *testing.T) {
+	// One token a second, in bursts of up to three.
+	limit := Limit{Requests: 60, Period: time.Minute, Burst: 3}
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	type take struct {
+		at         time.Duration
+		key        string
+		allowed    bool
+		remaining  int
+		reset      time.Duration
+		retryAfter time.Duration
+	}
+	tests := []struct {
+		name  string
+		takes []take
+	}{
+		{
+			name: "burst then denied",
+			takes: []take{
+				{at: 0, allowed: true, remaining: 2, reset: time.Second},
+				{at: 0, allowed: true, remaining: 1, reset: 2 * time.Second},
+				{at: 0, allowed: true, remaining: 0, reset: 3 * time.Second},
+				{at: 0, allowed: false, remaining: 0, reset: 3 * time.Second, retryAfter: time.Second},
+			},
+		},
+		{
+			name: "retry after counts down",
+			takes: []take{
+				{at: 0, allowed: true, remaining: 2, reset: time.Second},
+				{at: 0, allowed: true, remaining: 1, reset: 2 * time.Second},
+				{at: 0, allowed: true, remaining: 0, reset: 3 * time.Second},
+				{at: 250 * time.Millisecond, allowed: false, remaining: 0, reset: 2750 * time.Millisecond, retryAfter: 750 * time.Millisecond},
+				{at: 999 * time.Millisecond, allowed: false, remaining: 0, reset: 2001 * time.Millisecond, retryAfter: time.Millisecond},
+				{at: time.Second, allowed: true, remaining: 0, reset: 3 * time.Second},
+			},
+		},
+		{
+			name: "refill one token at a time",
+			takes: []take{
+				{at: 0, allowed: true, remaining: 2, reset: time.Second},
+				{at: 0, allowed: true, remaining: 1, reset: 2 * time.Second},
+				{at: 0, allowed: true, remaining: 0, reset: 3 * time.Second},
+				{at: 1500 * time.Millisecond, allowed: true, remaining: 0, reset: 2500 * time.Millisecond},
+				{at: 1500 * time.Millisecond, allowed: false, remaining: 0, reset: 2500 * time.Millisecond, retryAfter: 500 * time.Millisecond},
+				{at: 3500 * time.Millisecond, allowed: true, remaining: 1, reset: 1500 * time.Millisecond},
+			},
+		},
+		{
+			name: "full again after the reset",
+			takes: []take{
+				{at: 0, allowed: true, remaining: 2, reset: time.Second},
+				{at: 0, allowed: true, remaining: 1, reset: 2 * time.Second},
+				{at: 0, allowed: true, remaining: 0, reset: 3 * time.Second},
+				{at: 3 * time.Second, allowed: true, remaining: 2, reset: time.Second},
+				{at: time.Hour, allowed: true, remaining: 2, reset: time.Second},
+			},
+		},
+		{
+			name: "keys have their own buckets",
+			takes: []take{
+				{at: 0, key: "a", allowed: true, remaining: 2, reset: time.Second},
+				{at: 0, key: "a", allowed: true, remaining: 1, reset: 2 * time.Second},
+				{at: 0, key: "a", allowed: true, remaining: 0, reset: 3 * time.Second},
+				{at: 0, key: "a", allowed: false, remaining: 0, reset: 3 * time.Second, retryAfter: time.Second},
+				{at: 0, key: "b", allowed: true, remaining: 2, reset: time.Second},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := MemoryStore()
+			for i, take := range tt.takes {
+				key := take.key
+				if key == "" {
+					key = "ip:192.0.2.1"
+				}
+				got, err := store.Take(key, limit, start.Add(take.at))
+				if err != nil {
+					t.Fatalf("take %d: %v", i, err)
+				}
+				want := Result{Allowed: take.allowed, Limit: limit.Burst, Remaining: take.remaining, Reset: take.reset, RetryAfter: take.retryAfter}
+				if got != want {
+					t.Fatalf("take %d at %v = %+v, want %+v", i, take.at, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryStoreSweep(t *testing.T) {
+	limit := Limit{Requests: 1, Period: time.Second, Burst: 1}
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	store := MemoryStore().(*memoryStore)
+
+	for _, key := range []string{"a", "b"} {
+		if _, err := store.Take(key, limit, start); err != nil {
+			t.Fatalf("Take: %v", err)
+		}
+	}
+	if _, err := store.Take("c", limit, start.Add(sweepEvery)); err != nil {
+		t.Fatalf("Take: %v", err)
+	}
+	if _, ok := store.buckets["a"]; ok || len(store.buckets) != 1 {
+		t.Fatalf("after the sweep %d buckets are left, want only the new one", len(store.buckets))
+	}
+}
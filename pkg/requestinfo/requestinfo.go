@@ -0,0 +1,50 @@
+package requestinfo
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// Header carries the request ID, both ways.
+const Header = "X-Request-ID"
+
+// Info describes the HTTP request a piece of work is done for.
+type Info struct {
+	ID       string
+	ClientIP string
+}
+
+type contextKey struct{}
+
+func With(ctx context.Context, info Info) context.Context {
+	return context.WithValue(ctx, contextKey{}, info)
+}
+
+// From returns the request info stored by With, or the zero Info for work that is not tied
+// to a request, such as background jobs.
+func From(ctx context.Context) Info {
+	info, _ := ctx.Value(contextKey{}).(Info)
+	return info
+}
+
+// NewID returns a random 128-bit request ID in hex.
+func NewID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ValidID reports whether a client-supplied request ID is safe to reuse: at most 128 visible
+// ASCII characters.
+func ValidID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
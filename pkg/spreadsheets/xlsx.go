@@ -0,0 +1,157 @@
+package spreadsheets
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxCellLength is the most characters Excel accepts in a cell; longer text makes it "repair" the file.
+const maxCellLength = 32767
+
+const (
+	contentTypesXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`
+	rootRelsXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`
+	workbookRelsXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`</Relationships>`
+	sheetStartXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+	sheetEndXML = `</sheetData></worksheet>`
+)
+
+// XLSXWriter writes a single-sheet XLSX workbook row by row straight to the underlying writer,
+// so a sheet of any length is produced without holding it in memory. Text is written as inline
+// strings, which spares the shared-strings table that would otherwise have to be built up front.
+type XLSXWriter struct {
+	zip   *zip.Writer
+	sheet io.Writer
+	row   int
+}
+
+// NewXLSXWriter starts a workbook with one sheet called sheetName.
+func NewXLSXWriter(w io.Writer, sheetName string) (*XLSXWriter, error) {
+	zw := zip.NewWriter(w)
+
+	var workbook strings.Builder
+	workbook.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="`)
+	if err := xml.EscapeText(&workbook, []byte(sanitizeSheetName(sheetName))); err != nil {
+		return nil, err
+	}
+	workbook.WriteString(`" sheetId="1" r:id="rId1"/></sheets></workbook>`)
+
+	parts := []struct{ name, body string }{
+		{"[Content_Types].xml", contentTypesXML},
+		{"_rels/.rels", rootRelsXML},
+		{"xl/workbook.xml", workbook.String()},
+		{"xl/_rels/workbook.xml.rels", workbookRelsXML},
+	}
+	for _, part := range parts {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, part.body); err != nil {
+			return nil, err
+		}
+	}
+
+	sheet, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(sheet, sheetStartXML); err != nil {
+		return nil, err
+	}
+
+	return &XLSXWriter{zip: zw, sheet: sheet}, nil
+}
+
+// WriteRow appends a row. Integers and floats become numeric cells, times are written in
+// RFC 3339, nil leaves the cell empty, and anything else is written as text.
+func (w *XLSXWriter) WriteRow(values ...interface{}) error {
+	w.row++
+
+	var b strings.Builder
+	b.WriteString(`<row r="`)
+	b.WriteString(strconv.Itoa(w.row))
+	b.WriteString(`">`)
+	for _, value := range values {
+		switch v := value.(type) {
+		case nil:
+			b.WriteString(`<c/>`)
+		case int:
+			b.WriteString(`<c><v>` + strconv.Itoa(v) + `</v></c>`)
+		case int64:
+			b.WriteString(`<c><v>` + strconv.FormatInt(v, 10) + `</v></c>`)
+		case float64:
+			b.WriteString(`<c><v>` + strconv.FormatFloat(v, 'f', -1, 64) + `</v></c>`)
+		case time.Time:
+			writeText(&b, v.Format(time.RFC3339))
+		case string:
+			writeText(&b, v)
+		default:
+			writeText(&b, fmt.Sprint(v))
+		}
+	}
+	b.WriteString(`</row>`)
+
+	_, err := io.WriteString(w.sheet, b.String())
+	return err
+}
+
+// Flush pushes the rows written so far to the underlying writer.
+func (w *XLSXWriter) Flush() error {
+	return w.zip.Flush()
+}
+
+// Close finishes the sheet and the archive. It does not close the underlying writer.
+func (w *XLSXWriter) Close() error {
+	if _, err := io.WriteString(w.sheet, sheetEndXML); err != nil {
+		return err
+	}
+	return w.zip.Close()
+}
+
+func writeText(b *strings.Builder, text string) {
+	if utf8.RuneCountInString(text) > maxCellLength {
+		text = string([]rune(text)[:maxCellLength])
+	}
+	b.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+	// EscapeText also replaces characters XML cannot carry, such as most control characters.
+	_ = xml.EscapeText(b, []byte(text))
+	b.WriteString(`</t></is></c>`)
+}
+
+// sanitizeSheetName drops the characters Excel forbids in sheet names and keeps at most 31.
+func sanitizeSheetName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return -1
+		}
+		return r
+	}, name)
+	if utf8.RuneCountInString(name) > 31 {
+		name = string([]rune(name)[:31])
+	}
+	if name == "" {
+		return "Sheet1"
+	}
+	return name
+}
@@ -0,0 +1,138 @@
+package spreadsheets
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cell is what a reader sees in a worksheet cell: its type attribute and its value or inline text.
+type cell struct {
+	Type  string `xml:"t,attr"`
+	Value string `xml:"v"`
+	Text  string `xml:"is>t"`
+}
+
+type worksheet struct {
+	Rows []struct {
+		R     int    `xml:"r,attr"`
+		Cells []cell `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+type workbook struct {
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+func readPart(t *testing.T, archive *zip.Reader, name string, into interface{}) {
+	t.Helper()
+	f, err := archive.Open(name)
+	if err != nil {
+		t.Fatalf("open %s: %v", name, err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("read %s: %v", name, err)
+	}
+	if err := xml.Unmarshal(data, into); err != nil {
+		t.Fatalf("parse %s: %v\n%s", name, err, data)
+	}
+}
+
+func TestXLSXWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewXLSXWriter(&buf, "Beers: 2024/05")
+	if err != nil {
+		t.Fatalf("NewXLSXWriter: %v", err)
+	}
+
+	created := time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC)
+	rows := [][]interface{}{
+		{"id", "name", "created_at"},
+		{1, "Leo <Lager> & \"friends\"", created},
+		{int64(2), "  spaced  ", nil},
+		{1.5, "ช้าง\x01", true},
+		{3, strings.Repeat("a", maxCellLength+10)},
+	}
+	for i, row := range rows {
+		if err := w.WriteRow(row...); err != nil {
+			t.Fatalf("WriteRow %d: %v", i, err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatalf("Flush: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("not a zip archive: %v", err)
+	}
+	var names []string
+	for _, f := range archive.File {
+		names = append(names, f.Name)
+	}
+	wantNames := []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/worksheets/sheet1.xml"}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Fatalf("parts %v, want %v", names, wantNames)
+	}
+
+	var book workbook
+	readPart(t, archive, "xl/workbook.xml", &book)
+	if len(book.Sheets) != 1 || book.Sheets[0].Name != "Beers 202405" {
+		t.Fatalf("sheets %+v, want one called %q", book.Sheets, "Beers 202405")
+	}
+
+	var sheet worksheet
+	readPart(t, archive, "xl/worksheets/sheet1.xml", &sheet)
+	text := func(s string) cell { return cell{Type: "inlineStr", Text: s} }
+	number := func(s string) cell { return cell{Value: s} }
+	want := [][]cell{
+		{text("id"), text("name"), text("created_at")},
+		{number("1"), text(`Leo <Lager> & "friends"`), text("2024-05-01T08:30:00Z")},
+		{number("2"), text("  spaced  "), {}},
+		{number("1.5"), text("ช้าง�"), text("true")},
+		{number("3"), text(strings.Repeat("a", maxCellLength))},
+	}
+	if len(sheet.Rows) != len(want) {
+		t.Fatalf("got %d rows, want %d", len(sheet.Rows), len(want))
+	}
+	for i, row := range sheet.Rows {
+		if row.R != i+1 {
+			t.Errorf("row %d numbered %d", i+1, row.R)
+		}
+		if !reflect.DeepEqual(row.Cells, want[i]) {
+			t.Errorf("row %d = %+v, want %+v", i+1, row.Cells, want[i])
+		}
+	}
+}
+
+func TestSanitizeSheetName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Beers", "Beers"},
+		{"a[b]c:d*e?f/g\\h", "abcdefgh"},
+		{strings.Repeat("x", 40), strings.Repeat("x", 31)},
+		{strings.Repeat("เบียร์", 10), string([]rune(strings.Repeat("เบียร์", 10))[:31])},
+		{"", "Sheet1"},
+		{"/?*", "Sheet1"},
+	}
+
+	for _, tt := range tests {
+		if got := sanitizeSheetName(tt.name); got != tt.want {
+			t.Errorf("sanitizeSheetName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package storages
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+type memoryObject struct {
+	data        []byte
+	contentType string
+	modTime     time.Time
+}
+
+type memoryImageStore struct {
+	mu      sync.RWMutex
+	objects map[string]*memoryObject
+}
+
+// MemoryImageStore keeps files in process memory. It is meant for local development and tests;
+// like the local store, its files are served through the API.
+func MemoryImageStore() IImageStore {
+	return &memoryImageStore{
+		objects: make(map[string]*memoryObject),
+	}
+}
+
+func (s *memoryImageStore) Put(key string, body io.Reader, contentType string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = &memoryObject{
+		data:        data,
+		contentType: contentType,
+		modTime:     time.Now().UTC(),
+	}
+	return nil
+}
+
+func (s *memoryImageStore) Get(key string) (*Object, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	obj, ok := s.objects[key]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, ErrObjectNotFound
+	}
+
+	return &Object{
+		Body:        nopSeekCloser{bytes.NewReader(obj.data)},
+		Size:        int64(len(obj.data)),
+		ContentType: obj.contentType,
+		ModTime:     obj.modTime,
+		ETag:        fmt.Sprintf(`"%x"`, md5.Sum(obj.data)),
+	}, nil
+}
+
+func (s *memoryImageStore) Delete(key string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+	return nil
+}
+
+func (s *memoryImageStore) URL(key string) string {
+	return ""
+}
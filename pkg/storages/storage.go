@@ -0,0 +1,72 @@
+package storages
+
+import (
+	"errors"
+	"fmt"
+	"github.com/peedans/beerleo/config"
+	"io"
+	"strings"
+	"time"
+)
+
+var ErrObjectNotFound = errors.New("object not found")
+
+type IImageStore interface {
+	Put(key string, body io.Reader, contentType string) error
+	Get(key string) (*Object, error)
+	Delete(key string) error
+	// URL is the address clients download the object from directly, or "" when the store has
+	// none and the object must be served through the API.
+	URL(key string) string
+}
+
+// Object is a stored file opened for reading. Callers must close Body.
+type Object struct {
+	Body        io.ReadSeekCloser
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+	ETag        string
+}
+
+// ImageStore builds the backend selected by STORAGE_DRIVER.
+func ImageStore(cfg config.IStorageConfig) (IImageStore, error) {
+	switch cfg.Driver() {
+	case "", "local":
+		return LocalImageStore(cfg.LocalRoot()), nil
+	case "s3":
+		return S3ImageStore(&S3Options{
+			Endpoint:  cfg.S3Endpoint(),
+			Region:    cfg.S3Region(),
+			Bucket:    cfg.S3Bucket(),
+			AccessKey: cfg.S3AccessKey(),
+			SecretKey: cfg.S3SecretKey(),
+			PathStyle: cfg.S3PathStyle(),
+			PublicURL: cfg.PublicURL(),
+		})
+	case "memory":
+		return MemoryImageStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", cfg.Driver())
+	}
+}
+
+// cleanKey rejects keys that could escape the store, such as absolute paths or "..".
+func cleanKey(key string) (string, error) {
+	key = strings.TrimLeft(key, "/")
+	if key == "" {
+		return "", fmt.Errorf("empty object key")
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return "", fmt.Errorf("invalid object key %q", key)
+		}
+	}
+	return key, nil
+}
+
+type nopSeekCloser struct {
+	io.ReadSeeker
+}
+
+func (nopSeekCloser) Close() error { return nil }
@@ -0,0 +1,119 @@
+package storages
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestCleanKey(t *testing.T) {
+	tests := []struct {
+		key     string
+		want    string
+		wantErr bool
+	}{
+		{key: "beers/1/abc.png", want: "beers/1/abc.png"},
+		{key: "/beers/1/abc.png", want: "beers/1/abc.png"},
+		{key: "//beers/1/abc.png", want: "beers/1/abc.png"},
+		{key: "", wantErr: true},
+		{key: "/", wantErr: true},
+		{key: "..", wantErr: true},
+		{key: "../etc/passwd", wantErr: true},
+		{key: "beers/../../etc/passwd", wantErr: true},
+		{key: "beers/1/..", wantErr: true},
+		{key: "./beers/1/abc.png", wantErr: true},
+		{key: "beers//abc.png", wantErr: true},
+		{key: "beers/1/", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := cleanKey(tt.key)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("cleanKey(%q) = %q, want an error", tt.key, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("cleanKey(%q) failed: %v", tt.key, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("cleanKey(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+// TestImageStoreRoundTrip runs the same checks against every backend that needs no network.
+func TestImageStoreRoundTrip(t *testing.T) {
+	stores := map[string]func(t *testing.T) IImageStore{
+		"local": func(t *testing.T) IImageStore {
+			return LocalImageStore(t.TempDir())
+		},
+		"memory": func(t *testing.T) IImageStore {
+			return MemoryImageStore()
+		},
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+			const key = "beers/1/abc.png"
+			data := []byte("\x89PNG\r\n\x1a\nnot really an image")
+
+			if _, err := store.Get(key); !errors.Is(err, ErrObjectNotFound) {
+				t.Fatalf("Get before Put: got %v, want ErrObjectNotFound", err)
+			}
+
+			if err := store.Put(key, bytes.NewReader(data), "image/png"); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+
+			obj, err := store.Get(key)
+			if err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+			got, err := io.ReadAll(obj.Body)
+			obj.Body.Close()
+			if err != nil {
+				t.Fatalf("read body: %v", err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("Get body = %q, want %q", got, data)
+			}
+			if obj.Size != int64(len(data)) {
+				t.Errorf("Get size = %d, want %d", obj.Size, len(data))
+			}
+			if obj.ContentType != "image/png" {
+				t.Errorf("Get content type = %q, want image/png", obj.ContentType)
+			}
+			if obj.ETag == "" {
+				t.Error("Get returned no ETag")
+			}
+
+			if url := store.URL(key); url != "" {
+				t.Errorf("URL = %q, want none: the API serves the object", url)
+			}
+
+			if err := store.Delete(key); err != nil {
+				t.Fatalf("Delete failed: %v", err)
+			}
+			if _, err := store.Get(key); !errors.Is(err, ErrObjectNotFound) {
+				t.Errorf("Get after Delete: got %v, want ErrObjectNotFound", err)
+			}
+			if err := store.Delete(key); err != nil {
+				t.Errorf("Delete of a missing key failed: %v", err)
+			}
+
+			for _, bad := range []string{"../escape.png", "beers/../../escape.png", ""} {
+				if err := store.Put(bad, bytes.NewReader(data), "image/png"); err == nil {
+					t.Errorf("Put(%q) succeeded, want an error", bad)
+				}
+				if _, err := store.Get(bad); err == nil || errors.Is(err, ErrObjectNotFound) {
+					t.Errorf("Get(%q) = %v, want a key error", bad, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package uploads
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrTooLarge          = errors.New("image is too large")
+	ErrUnsupportedType   = errors.New("image type is not supported")
+	ErrExtensionMismatch = errors.New("image extension does not match its content")
+	ErrTooManyPixels     = errors.New("image dimensions are too large")
+	ErrInvalidImage      = errors.New("image cannot be decoded")
+)
+
+// allowedTypes maps each accepted MIME type to the extensions a client may use for it.
+// The first extension is the one used for the stored file.
+var allowedTypes = map[string][]string{
+	"image/jpeg": {".jpg", ".jpeg"},
+	"image/png":  {".png"},
+	"image/webp": {".webp"},
+	"image/gif":  {".gif"},
+}
+
+func AllowedTypes() []string {
+	return []string{"image/jpeg", "image/png", "image/webp", "image/gif"}
+}
+
+type Image struct {
+	Data        []byte
+	ContentType string
+	Ext         string
+	Hash        string
+	// Width and Height are read from the header; they are zero for WebP, which the standard
+	// library cannot decode.
+	Width  int
+	Height int
+}
+
+// FileName is the content-addressed name the image is stored under.
+func (i *Image) FileName() string {
+	return i.Hash + i.Ext
+}
+
+// ValidateImage reads an uploaded file, enforcing maxSize and checking that the bytes are a
+// supported image whose type agrees with the client-supplied extension. Images of more than
+// maxPixels pixels are rejected from their header alone: a small file can declare dimensions
+// whose decoded bitmap would not fit in memory.
+func ValidateImage(file *multipart.FileHeader, maxSize, maxPixels int64) (*Image, error) {
+	if maxSize > 0 && file.Size > maxSize {
+		return nil, ErrTooLarge
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	return ReadImage(file.Filename, src, maxSize, maxPixels)
+}
+
+// ReadImage applies the ValidateImage checks to an image read from r, e.g. a zip entry;
+// name supplies the extension.
+func ReadImage(name string, r io.Reader, maxSize, maxPixels int64) (*Image, error) {
+	reader := r
+	if maxSize > 0 {
+		reader = io.LimitReader(r, maxSize+1)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if maxSize > 0 && int64(len(data)) > maxSize {
+		return nil, ErrTooLarge
+	}
+
+	contentType := http.DetectContentType(data)
+	exts, ok := allowedTypes[contentType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedType, contentType)
+	}
+
+	ext := strings.ToLower(filepath.Ext(name))
+	if !contains(exts, ext) {
+		return nil, fmt.Errorf("%w: %q is not a valid extension for %s", ErrExtensionMismatch, ext, contentType)
+	}
+
+	img := &Image{
+		Data:        data,
+		ContentType: contentType,
+		Ext:         exts[0],
+	}
+	if contentType != "image/webp" {
+		config, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+		}
+		if err := checkPixels(config, maxPixels); err != nil {
+			return nil, err
+		}
+		img.Width, img.Height = config.Width, config.Height
+	}
+
+	sum := sha256.Sum256(data)
+	img.Hash = hex.EncodeToString(sum[:])
+	return img, nil
+}
+
+// checkPixels rejects dimensions above maxPixels; zero or less means no limit.
+func checkPixels(config image.Config, maxPixels int64) error {
+	if config.Width <= 0 || config.Height <= 0 {
+		return fmt.Errorf("%w: %dx%d", ErrInvalidImage, config.Width, config.Height)
+	}
+	if maxPixels > 0 && int64(config.Width)*int64(config.Height) > maxPixels {
+		return fmt.Errorf("%w: %dx%d is over %d pixels", ErrTooManyPixels, config.Width, config.Height, maxPixels)
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,188 @@
+package uploads
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// VariantSizes are the bounding boxes, in pixels, of the resized copies stored next to every original.
+var VariantSizes = []int{128, 512}
+
+type Variant struct {
+	Size        int
+	Data        []byte
+	ContentType string
+}
+
+// variantExt is the extension variants of an original are encoded with. WebP has no encoder
+// in the standard library, so WebP originals have no variants and clients fall back to the original.
+var variantExt = map[string]string{
+	".jpg":  ".jpg",
+	".jpeg": ".jpg",
+	".png":  ".png",
+	".gif":  ".png",
+}
+
+// HasVariants reports whether key names a content-addressed upload, the only kind stored with variants.
+func HasVariants(key string) bool {
+	stem := strings.TrimSuffix(path.Base(key), path.Ext(key))
+	if _, ok := variantExt[strings.ToLower(path.Ext(key))]; !ok || len(stem) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(stem)
+	return err == nil
+}
+
+// VariantKey derives the storage key of the size variant of an original image key.
+func VariantKey(key string, size int) (string, bool) {
+	ext := strings.ToLower(path.Ext(key))
+	vext, ok := variantExt[ext]
+	if !ok {
+		return "", false
+	}
+	return strings.TrimSuffix(key, path.Ext(key)) + "_" + strconv.Itoa(size) + vext, true
+}
+
+// ImageKeys returns the original key followed by the keys of all its variants.
+func ImageKeys(key string) []string {
+	keys := []string{key}
+	for _, size := range VariantSizes {
+		if variant, ok := VariantKey(key, size); ok {
+			keys = append(keys, variant)
+		}
+	}
+	return keys
+}
+
+// MaxDecodePixels caps the images Variants decodes, whatever limit ReadImage was given, since the
+// decoded bitmap takes 4 bytes per pixel.
+const MaxDecodePixels = 50_000_000
+
+// Variants decodes img and returns one downscaled copy per VariantSizes entry.
+// Images already smaller than a size are re-encoded without upscaling.
+func Variants(img *Image) ([]*Variant, error) {
+	vext, ok := variantExt[img.Ext]
+	if !ok {
+		return nil, nil
+	}
+
+	// Check the dimensions before decoding allocates a bitmap for them.
+	config, _, err := image.DecodeConfig(bytes.NewReader(img.Data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	if err := checkPixels(config, MaxDecodePixels); err != nil {
+		return nil, err
+	}
+
+	var src image.Image
+	switch img.ContentType {
+	case "image/jpeg":
+		src, err = jpeg.Decode(bytes.NewReader(img.Data))
+	case "image/png":
+		src, err = png.Decode(bytes.NewReader(img.Data))
+	case "image/gif":
+		src, err = gif.Decode(bytes.NewReader(img.Data))
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, src.Bounds().Dx(), src.Bounds().Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, src.Bounds().Min, draw.Src)
+
+	variants := make([]*Variant, 0, len(VariantSizes))
+	for _, size := range VariantSizes {
+		width, height := fit(rgba.Bounds().Dx(), rgba.Bounds().Dy(), size)
+
+		var buf bytes.Buffer
+		contentType := "image/png"
+		if vext == ".jpg" {
+			contentType = "image/jpeg"
+			err = jpeg.Encode(&buf, resize(rgba, width, height), &jpeg.Options{Quality: 85})
+		} else {
+			err = png.Encode(&buf, resize(rgba, width, height))
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		variants = append(variants, &Variant{
+			Size:        size,
+			Data:        buf.Bytes(),
+			ContentType: contentType,
+		})
+	}
+
+	return variants, nil
+}
+
+// fit scales width x height down so that the longer side is at most size, keeping the aspect ratio.
+func fit(width, height, size int) (int, int) {
+	if width <= size && height <= size {
+		return width, height
+	}
+	if width >= height {
+		return size, maxInt(1, height*size/width)
+	}
+	return maxInt(1, width*size/height), size
+}
+
+// resize downsamples src with a box filter: every destination pixel is the average of the
+// source pixels it covers.
+func resize(src *image.RGBA, width, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	srcW, srcH := src.Bounds().Dx(), src.Bounds().Dy()
+	if srcW == width && srcH == height {
+		copy(dst.Pix, src.Pix)
+		return dst
+	}
+
+	for y := 0; y < height; y++ {
+		y0 := y * srcH / height
+		y1 := maxInt(y0+1, (y+1)*srcH/height)
+		for x := 0; x < width; x++ {
+			x0 := x * srcW / width
+			x1 := maxInt(x0+1, (x+1)*srcW/width)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += uint64(p[0])
+					g += uint64(p[1])
+					b += uint64(p[2])
+					a += uint64(p[3])
+					n++
+				}
+			}
+
+			d := dst.Pix[y*dst.Stride+x*4:]
+			d[0] = uint8(r / n)
+			d[1] = uint8(g / n)
+			d[2] = uint8(b / n)
+			d[3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}